@@ -3,19 +3,16 @@ package main
 import (
 	"database/sql"
 	"net/http"
-
-	"gopkg.in/DataDog/dd-trace-go.v1/ddtrace/tracer"
 )
 
-func auth(db *sql.DB, w http.ResponseWriter, r *http.Request) (int, bool) {
-	span, ctx := tracer.StartSpanFromContext(r.Context(), "auth")
+func auth(db Store, w http.ResponseWriter, r *http.Request) (int, bool) {
+	ctx, end := startPhase(r.Context(), "auth")
 	var err error
-	defer func() { span.Finish(tracer.WithError(err)) }()
+	defer func() { end(err) }()
 
 	apiKey := r.URL.Query().Get("key")
-	row := db.QueryRowContext(ctx, "SELECT id FROM users WHERE api_key = $1", apiKey)
-	var userID int
-	if err = row.Scan(&userID); err == sql.ErrNoRows {
+	userID, err := db.UserID(ctx, apiKey)
+	if err == sql.ErrNoRows {
 		respondErr(w, http.StatusForbidden, "invalid api key: %q\n", apiKey)
 		return 0, false
 	} else if err != nil {
@@ -0,0 +1,200 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	_ "embed"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/go-sql-driver/mysql"
+	"github.com/jackc/pgx/v4/stdlib"
+	"github.com/mattn/go-sqlite3"
+	sqltrace "gopkg.in/DataDog/dd-trace-go.v1/contrib/database/sql"
+)
+
+var (
+	//go:embed schema_mysql.sql
+	schemaMySQL string
+	//go:embed schema_sqlite.sql
+	schemaSQLite string
+)
+
+// Dialect holds everything that differs between the supported database
+// backends.
+type Dialect struct {
+	// Driver is the database/sql driver used for this dialect.
+	Driver driver.Driver
+	// DriverName is the name the traced driver is registered under, if it
+	// differs from the dialect name. dd-trace-go derives the default service
+	// name from it, e.g. pgx.db.
+	DriverName string
+	// DefaultDSN is used if no dsn is given by the user.
+	DefaultDSN string
+	// Schema creates the tables and fixtures used by the app. It's applied
+	// in a single Exec call.
+	Schema string
+	// UserQuery selects the id of the user with the given api key.
+	UserQuery string
+	// PostsQuery selects the posts of the given user id while sleeping for
+	// the given number of seconds to simulate a slow query.
+	PostsQuery string
+	// InsertTransactionQuery inserts a transaction for the given user id
+	// and data.
+	InsertTransactionQuery string
+	// LastInsertID is true if InsertTransactionQuery doesn't return the id
+	// of the new row, and sql.Result.LastInsertId has to be used instead.
+	LastInsertID bool
+	// UsersTableExistsQuery returns true if the users table exists.
+	UsersTableExistsQuery string
+}
+
+// dialects holds all supported database backends by name.
+var dialects = map[string]*Dialect{
+	"postgres": {
+		Driver:                 stdlib.GetDefaultDriver(),
+		DriverName:             "pgx",
+		DefaultDSN:             "postgres://",
+		Schema:                 schemaSQL,
+		UserQuery:              `SELECT id FROM users WHERE api_key = $1`,
+		PostsQuery:             `SELECT id, user_id, title, body FROM posts, pg_sleep($2) WHERE user_id = $1`,
+		InsertTransactionQuery: `INSERT INTO transactions (user_id, data) VALUES ($1, $2) RETURNING id`,
+		UsersTableExistsQuery: `
+		SELECT EXISTS (
+			SELECT FROM information_schema.tables
+			WHERE table_schema = 'public'
+			AND table_name = 'users'
+		);
+		`,
+	},
+	"mysql": {
+		Driver:     &mysql.MySQLDriver{},
+		DefaultDSN: "root@tcp(localhost:3306)/go_prof_app?multiStatements=true",
+		Schema:     schemaMySQL,
+		UserQuery:  `SELECT id FROM users WHERE api_key = ?`,
+		// The uncorrelated subquery is only evaluated once, so SLEEP() is
+		// not called for every row.
+		PostsQuery:             `SELECT id, user_id, title, body FROM posts WHERE user_id = ? AND (SELECT SLEEP(?)) = 0`,
+		InsertTransactionQuery: `INSERT INTO transactions (user_id, data) VALUES (?, ?)`,
+		LastInsertID:           true,
+		UsersTableExistsQuery: `
+		SELECT EXISTS (
+			SELECT 1 FROM information_schema.tables
+			WHERE table_schema = DATABASE()
+			AND table_name = 'users'
+		);
+		`,
+	},
+	"sqlite": {
+		// SQLite has no sleep function, so we provide our own.
+		Driver: &sqlite3.SQLiteDriver{
+			ConnectHook: func(conn *sqlite3.SQLiteConn) error {
+				return conn.RegisterFunc("sleep", sqliteSleep, false)
+			},
+		},
+		DefaultDSN:             "file:go-prof-app.db?_busy_timeout=5000&_journal_mode=WAL",
+		Schema:                 schemaSQLite,
+		UserQuery:              `SELECT id FROM users WHERE api_key = ?`,
+		PostsQuery:             `SELECT id, user_id, title, body FROM posts WHERE user_id = ? AND (SELECT sleep(?)) = 0`,
+		InsertTransactionQuery: `INSERT INTO transactions (user_id, data) VALUES (?, ?) RETURNING id`,
+		UsersTableExistsQuery: `
+		SELECT EXISTS (
+			SELECT 1 FROM sqlite_master
+			WHERE type = 'table'
+			AND name = 'users'
+		);
+		`,
+	},
+}
+
+// dialectNames returns the names of all supported dialects.
+func dialectNames() []string {
+	var names []string
+	for name := range dialects {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// sqliteSleep implements the sleep(seconds) function for SQLite.
+func sqliteSleep(seconds float64) int {
+	time.Sleep(time.Duration(seconds * float64(time.Second)))
+	return 0
+}
+
+// Database is a *sql.DB that knows how to run the app's queries against the
+// backend it's connected to.
+type Database struct {
+	*sql.DB
+	Dialect *Dialect
+}
+
+// OpenDatabase opens a traced database connection using the dialect with the
+// given name. If dsn is empty, the dialect's default dsn is used.
+func OpenDatabase(name, dsn string) (*Database, error) {
+	d, ok := dialects[name]
+	if !ok {
+		return nil, fmt.Errorf("unknown db driver: %q", name)
+	}
+	if dsn == "" {
+		dsn = d.DefaultDSN
+	}
+	driverName := name
+	if d.DriverName != "" {
+		driverName = d.DriverName
+	}
+	sqltrace.Register(driverName, d.Driver)
+	db, err := sqltrace.Open(driverName, dsn)
+	if err != nil {
+		return nil, err
+	}
+	return &Database{DB: db, Dialect: d}, nil
+}
+
+// UserID returns the id of the user with the given api key, or sql.ErrNoRows
+// if there is no such user.
+func (db *Database) UserID(ctx context.Context, apiKey string) (int, error) {
+	var userID int
+	err := db.QueryRowContext(ctx, db.Dialect.UserQuery, apiKey).Scan(&userID)
+	return userID, err
+}
+
+// Posts returns the posts of the given user. The query is artificially slowed
+// down to take at least sleep.
+func (db *Database) Posts(ctx context.Context, userID int, sleep time.Duration) ([]*Post, error) {
+	rows, err := db.QueryContext(ctx, db.Dialect.PostsQuery, userID, sleep.Seconds())
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var posts []*Post
+	for rows.Next() {
+		var p Post
+		if err = rows.Scan(&p.ID, &p.UserID, &p.Title, &p.Body); err != nil {
+			return nil, err
+		}
+		posts = append(posts, &p)
+	}
+	return posts, rows.Err()
+}
+
+// InsertTransaction records a new transaction for the given user and returns
+// its id.
+func (db *Database) InsertTransaction(ctx context.Context, userID int, data string) (int, error) {
+	if db.Dialect.LastInsertID {
+		res, err := db.ExecContext(ctx, db.Dialect.InsertTransactionQuery, userID, data)
+		if err != nil {
+			return 0, err
+		}
+		id, err := res.LastInsertId()
+		return int(id), err
+	}
+
+	var txID int
+	err := db.QueryRowContext(ctx, db.Dialect.InsertTransactionQuery, userID, data).Scan(&txID)
+	return txID, err
+}
@@ -0,0 +1,65 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func Test_sqliteDatabase(t *testing.T) {
+	dsn := "file:" + filepath.Join(t.TempDir(), "test.db")
+	db, err := OpenDatabase("sqlite", dsn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	if exists, err := checkUsersTableExists(db); err != nil {
+		t.Fatal(err)
+	} else if exists {
+		t.Fatalf("users table should not exist yet")
+	}
+	if err := applySchema(db); err != nil {
+		t.Fatal(err)
+	}
+	if exists, err := checkUsersTableExists(db); err != nil {
+		t.Fatal(err)
+	} else if !exists {
+		t.Fatalf("users table should exist")
+	}
+
+	ctx := context.Background()
+	userID, err := db.UserID(ctx, seedAPIKey)
+	if err != nil {
+		t.Fatal(err)
+	} else if userID != 1 {
+		t.Fatalf("got=%d want=%d", userID, 1)
+	}
+	if _, err := db.UserID(ctx, "invalid"); err != sql.ErrNoRows {
+		t.Fatalf("got=%v want=%v", err, sql.ErrNoRows)
+	}
+
+	start := time.Now()
+	posts, err := db.Posts(ctx, userID, 50*time.Millisecond)
+	if err != nil {
+		t.Fatal(err)
+	} else if len(posts) != 100 {
+		t.Fatalf("got=%d want=%d", len(posts), 100)
+	} else if posts[0].Title != "Post 1" {
+		t.Fatalf("got=%q want=%q", posts[0].Title, "Post 1")
+	}
+	if dt := time.Since(start); dt < 50*time.Millisecond {
+		t.Fatalf("query took %s, expected at least 50ms", dt)
+	}
+
+	for want := 1; want <= 2; want++ {
+		txID, err := db.InsertTransaction(ctx, userID, "foo")
+		if err != nil {
+			t.Fatal(err)
+		} else if txID != want {
+			t.Fatalf("got=%d want=%d", txID, want)
+		}
+	}
+}
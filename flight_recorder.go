@@ -0,0 +1,281 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"runtime/trace"
+	"sync"
+	"time"
+)
+
+var errFlightRecorderStopped = errors.New("flight recorder is not running")
+
+// flightRecorder continuously traces the app and keeps the last window of
+// execution trace data in memory, so it can be dumped to disk after something
+// interesting happened.
+//
+// runtime/trace can't cut a window out of a running trace, so the recorder
+// traces in back to back segments of a quarter window and keeps the segments
+// that overlap with the window. A snapshot is a directory containing each
+// segment as a separate trace file.
+type flightRecorder struct {
+	window  time.Duration
+	segment time.Duration
+	dir     string
+
+	reqCh chan flightRecorderReq
+	done  chan struct{}
+
+	mu        sync.Mutex
+	started   bool
+	segments  []traceSegment
+	buf       *bytes.Buffer
+	bufStart  time.Time
+	lastSlow  time.Time
+	snapshots int
+}
+
+type traceSegment struct {
+	Start time.Time
+	End   time.Time
+	Data  []byte
+}
+
+type flightRecorderReq struct {
+	Reason string
+	Result chan flightRecorderResult
+}
+
+type flightRecorderResult struct {
+	Path string
+	Err  error
+}
+
+// newFlightRecorder returns a recorder that keeps the last window of trace
+// data and saves snapshots to dir.
+func newFlightRecorder(window time.Duration, dir string) *flightRecorder {
+	return &flightRecorder{
+		window:  window,
+		segment: window / 4,
+		dir:     dir,
+		reqCh:   make(chan flightRecorderReq),
+		done:    make(chan struct{}),
+	}
+}
+
+// Start starts tracing. It fails if somebody else is already tracing, e.g.
+// because of the -trace flag.
+func (f *flightRecorder) Start() error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if err := f.startSegment(); err != nil {
+		return err
+	}
+	f.started = true
+	return nil
+}
+
+// Running reports whether the recorder was started and Run didn't return
+// yet. All that time the recorder holds runtime/trace, apart from the moments
+// between two segments, so nobody else can trace.
+func (f *flightRecorder) Running() bool {
+	f.mu.Lock()
+	started := f.started
+	f.mu.Unlock()
+	select {
+	case <-f.done:
+		return false
+	default:
+		return started
+	}
+}
+
+// Run rotates the trace segments and takes snapshots until ctx is done. Start
+// must be called first.
+func (f *flightRecorder) Run(ctx context.Context) {
+	defer close(f.done)
+	ticker := time.NewTicker(f.segment)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			f.rotate()
+		case req := <-f.reqCh:
+			f.rotate()
+			path, err := f.save(req.Reason)
+			req.Result <- flightRecorderResult{Path: path, Err: err}
+		case <-ctx.Done():
+			f.mu.Lock()
+			defer f.mu.Unlock()
+			if f.buf != nil {
+				trace.Stop()
+				f.buf = nil
+			}
+			return
+		}
+	}
+}
+
+// startSegment starts tracing into a new buffer. f.mu must be held.
+func (f *flightRecorder) startSegment() error {
+	buf := &bytes.Buffer{}
+	if err := trace.Start(buf); err != nil {
+		return err
+	}
+	f.buf, f.bufStart = buf, time.Now()
+	return nil
+}
+
+// rotate finishes the current segment, drops the segments that are no longer
+// part of the window and starts a new segment.
+func (f *flightRecorder) rotate() {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	now := time.Now()
+	if f.buf != nil {
+		trace.Stop()
+		f.segments = append(f.segments, traceSegment{Start: f.bufStart, End: now, Data: f.buf.Bytes()})
+		f.buf = nil
+	}
+	for len(f.segments) > 0 && f.segments[0].End.Before(now.Add(-f.window)) {
+		f.segments = f.segments[1:]
+	}
+	if err := f.startSegment(); err != nil {
+		// Somebody else is tracing, e.g. /debug/pprof/trace. Try again with
+		// the next segment.
+		log.Printf("Flight recorder failed to start segment: %s", err)
+	}
+}
+
+// save writes all segments to a new directory in f.dir and returns its path.
+func (f *flightRecorder) save(reason string) (string, error) {
+	f.mu.Lock()
+	segments := f.segments
+	f.snapshots++
+	n := f.snapshots
+	f.mu.Unlock()
+
+	if len(segments) == 0 {
+		return "", errors.New("no trace data recorded yet")
+	}
+	dir := filepath.Join(f.dir, fmt.Sprintf("flight-%s-%d", time.Now().UTC().Format("20060102-150405"), n))
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return "", err
+	}
+	for i, s := range segments {
+		path := filepath.Join(dir, fmt.Sprintf("segment-%02d.out", i))
+		if err := os.WriteFile(path, s.Data, 0644); err != nil {
+			return "", err
+		}
+	}
+	info := fmt.Sprintf("reason: %s\nstart: %s\nend: %s\nsegments: %d\n",
+		reason,
+		segments[0].Start.Format(time.RFC3339Nano),
+		segments[len(segments)-1].End.Format(time.RFC3339Nano),
+		len(segments),
+	)
+	if err := os.WriteFile(filepath.Join(dir, "snapshot.txt"), []byte(info), 0644); err != nil {
+		return "", err
+	}
+	return dir, nil
+}
+
+// Snapshot saves the trace data of the last window to disk and returns the
+// directory it was saved to. reason is saved alongside the trace.
+func (f *flightRecorder) Snapshot(reason string) (string, error) {
+	req := flightRecorderReq{Reason: reason, Result: make(chan flightRecorderResult, 1)}
+	select {
+	case f.reqCh <- req:
+	case <-f.done:
+		return "", errFlightRecorderStopped
+	}
+	res := <-req.Result
+	return res.Path, res.Err
+}
+
+// TriggerSlow takes a snapshot in the background, unless it was called less
+// than a window ago. This keeps bursts of slow requests from taking
+// snapshots of the same data over and over.
+func (f *flightRecorder) TriggerSlow(reason string) {
+	f.mu.Lock()
+	now := time.Now()
+	if now.Sub(f.lastSlow) < f.window {
+		f.mu.Unlock()
+		return
+	}
+	f.lastSlow = now
+	f.mu.Unlock()
+
+	go func() {
+		if path, err := f.Snapshot(reason); err != nil {
+			log.Printf("Failed to save flight recorder snapshot: %s", err)
+		} else {
+			log.Printf("Saved flight recorder snapshot to %q: %s", path, reason)
+		}
+	}()
+}
+
+// Status returns a human readable summary of the recorded data.
+func (f *flightRecorder) Status() string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	var size int
+	for _, s := range f.segments {
+		size += len(s.Data)
+	}
+	if f.buf != nil {
+		size += f.buf.Len()
+	}
+	return fmt.Sprintf("window=%s segments=%d bytes=%d tracing=%t snapshots=%d\n",
+		f.window, len(f.segments), size, f.buf != nil, f.snapshots)
+}
+
+// FlightRecorderAdminHandler shows the status of the flight recorder on GET
+// and saves a snapshot on POST.
+type FlightRecorderAdminHandler struct {
+	DB       Store
+	Recorder *flightRecorder
+}
+
+func (h FlightRecorderAdminHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if _, ok := auth(h.DB, w, r); !ok {
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		fmt.Fprint(w, h.Recorder.Status())
+	case http.MethodPost:
+		path, err := h.Recorder.Snapshot("admin request from " + r.RemoteAddr)
+		if err != nil {
+			respondErr(w, http.StatusInternalServerError, "snapshot: %s\n", err)
+			return
+		}
+		fmt.Fprintf(w, "saved snapshot to %s\n", path)
+	default:
+		respondErr(w, http.StatusMethodNotAllowed, "method not allowed: %s\n", r.Method)
+	}
+}
+
+// SlowRequestHandler takes a flight recorder snapshot when a request to the
+// wrapped handler takes longer than Threshold.
+type SlowRequestHandler struct {
+	Recorder  *flightRecorder
+	Threshold time.Duration
+	Route     string
+	Handler   http.Handler
+}
+
+func (h SlowRequestHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	start := time.Now()
+	h.Handler.ServeHTTP(w, r)
+	if d := time.Since(start); d >= h.Threshold {
+		h.Recorder.TriggerSlow(fmt.Sprintf("%s %s took %s", r.Method, h.Route, d))
+	}
+}
@@ -0,0 +1,12 @@
+//go:build !windows
+// +build !windows
+
+package main
+
+import (
+	"os"
+	"syscall"
+)
+
+// flightRecorderSignals trigger a flight recorder snapshot.
+var flightRecorderSignals = []os.Signal{syscall.SIGUSR1}
@@ -0,0 +1,10 @@
+//go:build windows
+// +build windows
+
+package main
+
+import "os"
+
+// flightRecorderSignals trigger a flight recorder snapshot. Windows has no
+// SIGUSR1, use the admin endpoint instead.
+var flightRecorderSignals []os.Signal
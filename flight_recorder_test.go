@@ -0,0 +1,90 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func Test_flightRecorder(t *testing.T) {
+	dir := t.TempDir()
+	f := newFlightRecorder(time.Second, dir)
+	if err := f.Start(); err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	go f.Run(ctx)
+	defer func() {
+		cancel()
+		<-f.done
+	}()
+
+	// Tracing is exclusive while the recorder is running.
+	if _, err := (&traceCapture{}).Start(ctx, &strings.Builder{}, time.Second, nil); err == nil {
+		t.Fatal("expected error")
+	}
+	store, err := NewMemoryStore("fixed")
+	if err != nil {
+		t.Fatal(err)
+	}
+	traceAdmin := TraceAdminHandler{DB: store, Capture: &traceCapture{}, FlightRecorder: f, Dir: dir}
+	rec := httptest.NewRecorder()
+	traceAdmin.ServeHTTP(rec, httptest.NewRequest("GET", "/admin/trace?key="+seedAPIKey, nil))
+	if rec.Code != http.StatusConflict || !strings.Contains(rec.Body.String(), "flight recorder active") {
+		t.Fatalf("got=%d %q want=%d", rec.Code, rec.Body, http.StatusConflict)
+	}
+
+	// Wait for more than a window, so old segments are dropped.
+	time.Sleep(1500 * time.Millisecond)
+	slow := SlowRequestHandler{
+		Recorder:  f,
+		Threshold: 10 * time.Millisecond,
+		Route:     "/slow",
+		Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			time.Sleep(20 * time.Millisecond)
+		}),
+	}
+	for i := 0; i < 3; i++ {
+		slow.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/slow", nil))
+	}
+
+	// Only the first slow request takes a snapshot.
+	var snapshots []string
+	for deadline := time.Now().Add(time.Second); len(snapshots) == 0; time.Sleep(10 * time.Millisecond) {
+		if time.Now().After(deadline) {
+			t.Fatal("no snapshot taken")
+		}
+		snapshots, _ = filepath.Glob(filepath.Join(dir, "flight-*", "snapshot.txt"))
+	}
+	if len(snapshots) != 1 {
+		t.Fatalf("got=%d want=%d", len(snapshots), 1)
+	}
+	info, err := os.ReadFile(snapshots[0])
+	if err != nil {
+		t.Fatal(err)
+	} else if !strings.Contains(string(info), "reason: GET /slow took") {
+		t.Fatalf("unexpected snapshot info: %s", info)
+	}
+
+	path, err := f.Snapshot("test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	segments, err := filepath.Glob(filepath.Join(path, "segment-*.out"))
+	if err != nil {
+		t.Fatal(err)
+	} else if len(segments) < 4 || len(segments) > 6 {
+		t.Fatalf("got=%d segments for a 1s window of 250ms segments", len(segments))
+	}
+	data, err := os.ReadFile(segments[0])
+	if err != nil {
+		t.Fatal(err)
+	} else if !strings.HasPrefix(string(data), "go 1.") {
+		t.Fatalf("not a trace: %q", data[:10])
+	}
+}
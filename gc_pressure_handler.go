@@ -0,0 +1,206 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"runtime"
+	"strconv"
+	"time"
+)
+
+const (
+	// gcPressureTick is the interval at which gcPressure allocates the bytes
+	// due for its rate.
+	gcPressureTick = 10 * time.Millisecond
+	// maxGCPressureObjectSize limits the size of a single allocated object.
+	maxGCPressureObjectSize = 64 << 20
+	// maxGCPressureBytes limits the bytes allocated by a single run, i.e.
+	// rate * duration, so a request can't exhaust the app's memory.
+	maxGCPressureBytes = 4 << 30
+	// maxGCPressureLifetime limits how long a run keeps its objects alive,
+	// so concurrent requests can't pin their allocations indefinitely.
+	maxGCPressureLifetime = time.Minute
+)
+
+// GCPressureHandler allocates memory at a given rate to put pressure on the
+// garbage collector, see gcPressure.
+//
+// The following query parameters are supported and override the defaults
+// given by the handler fields:
+//
+//	rate:     Bytes allocated per second, e.g. 64MiB
+//	duration: How long to allocate for, e.g. 100ms
+//	lifetime: How long allocated objects are kept alive, e.g. 1s, at most 1m
+//	size:     Size of the allocated objects, e.g. 1KiB
+//	pointers: Fraction of the allocated bytes that are pointers, 0 to 1
+type GCPressureHandler struct {
+	DB             Store
+	Rate           int64
+	Duration       time.Duration
+	Lifetime       time.Duration
+	ObjectSize     int64
+	PointerDensity float64
+}
+
+func (h GCPressureHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if _, ok := auth(h.DB, w, r); !ok {
+		return
+	}
+
+	q := r.URL.Query()
+	p := gcPressure{
+		Rate:           h.Rate,
+		Duration:       h.Duration,
+		Lifetime:       h.Lifetime,
+		ObjectSize:     h.ObjectSize,
+		PointerDensity: h.PointerDensity,
+	}
+	for name, dst := range map[string]*int64{"rate": &p.Rate, "size": &p.ObjectSize} {
+		if val := q.Get(name); val != "" {
+			n, err := parseByteSize(val)
+			if err != nil {
+				respondErr(w, http.StatusBadRequest, "bad %s: %s\n", name, err)
+				return
+			}
+			*dst = n
+		}
+	}
+	for name, dst := range map[string]*time.Duration{"duration": &p.Duration, "lifetime": &p.Lifetime} {
+		if val := q.Get(name); val != "" {
+			d, err := time.ParseDuration(val)
+			if err != nil {
+				respondErr(w, http.StatusBadRequest, "bad %s: %s\n", name, err)
+				return
+			}
+			*dst = d
+		}
+	}
+	if val := q.Get("pointers"); val != "" {
+		f, err := strconv.ParseFloat(val, 64)
+		if err != nil {
+			respondErr(w, http.StatusBadRequest, "bad pointers: %s\n", err)
+			return
+		}
+		p.PointerDensity = f
+	}
+
+	start := time.Now()
+	allocated, objects, err := p.Run(r.Context())
+	if err != nil {
+		respondErr(w, http.StatusBadRequest, "%s\n", err)
+		return
+	}
+	fmt.Fprintf(w, "allocated %d bytes in %d objects in %s\n", allocated, objects, time.Since(start))
+}
+
+// gcPressure allocates Rate bytes per second for Duration. The allocated
+// objects are ObjectSize bytes large and kept alive for Lifetime, which
+// determines the size of the live heap the GC has to mark: about Rate *
+// Lifetime bytes.
+//
+// PointerDensity is the fraction of the allocated bytes that are pointers.
+// Objects either contain only pointers or none at all, and are mixed to
+// match the density. The GC has to scan the pointer objects and follow their
+// pointers, while the pointer-free ones are only marked.
+type gcPressure struct {
+	Rate           int64
+	Duration       time.Duration
+	Lifetime       time.Duration
+	ObjectSize     int64
+	PointerDensity float64
+}
+
+// Run allocates until Duration elapsed or ctx is done and returns the number
+// of bytes and objects allocated. This is less than Rate * Duration if the
+// app can't allocate that fast. Objects that are still alive when Run
+// returns are released once their Lifetime is over.
+func (p gcPressure) Run(ctx context.Context) (allocated int64, objects int, err error) {
+	if p.Rate < 0 || p.Duration < 0 || p.Lifetime < 0 {
+		return 0, 0, fmt.Errorf("rate, duration and lifetime must not be negative")
+	} else if p.Lifetime > maxGCPressureLifetime {
+		return 0, 0, fmt.Errorf("lifetime must not exceed %s: %s", maxGCPressureLifetime, p.Lifetime)
+	} else if p.ObjectSize < 8 || p.ObjectSize > maxGCPressureObjectSize {
+		return 0, 0, fmt.Errorf("object size must be between 8 and %d bytes: %d", maxGCPressureObjectSize, p.ObjectSize)
+	} else if p.PointerDensity < 0 || p.PointerDensity > 1 {
+		return 0, 0, fmt.Errorf("pointer density must be between 0 and 1: %g", p.PointerDensity)
+	}
+	totalF := float64(p.Rate) * p.Duration.Seconds()
+	if totalF > maxGCPressureBytes {
+		return 0, 0, fmt.Errorf("rate * duration must not exceed %d bytes: %.0f", maxGCPressureBytes, totalF)
+	}
+
+	total := int64(totalF)
+	var ptrBytes int64
+	ticker := time.NewTicker(gcPressureTick)
+	defer ticker.Stop()
+	start := time.Now()
+	// Give the last tick time to finish, but don't keep allocating if the
+	// rate is more than the app can allocate.
+	deadline := start.Add(p.Duration + gcPressureTick)
+	for {
+		due := int64(float64(p.Rate) * time.Since(start).Seconds())
+		if due > total {
+			due = total
+		}
+		var batch gcBatch
+		for ; allocated+p.ObjectSize <= due; allocated += p.ObjectSize {
+			if objects%64 == 0 && time.Now().After(deadline) {
+				break
+			}
+			if float64(ptrBytes) < p.PointerDensity*float64(allocated+p.ObjectSize) {
+				batch.allocPointers(p.ObjectSize)
+				ptrBytes += p.ObjectSize
+			} else {
+				batch.allocBytes(p.ObjectSize)
+			}
+			objects++
+		}
+		batch.retain(p.Lifetime)
+
+		if allocated+p.ObjectSize > total || time.Since(start) >= p.Duration {
+			return allocated, objects, nil
+		}
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			return allocated, objects, ctx.Err()
+		}
+	}
+}
+
+// gcBatch holds the objects allocated by gcPressure during one tick.
+type gcBatch struct {
+	bytes    [][]byte
+	pointers [][]*byte
+}
+
+//go:noinline
+func (b *gcBatch) allocBytes(size int64) {
+	b.bytes = append(b.bytes, make([]byte, size))
+}
+
+// allocPointers allocates an object of pointers to the most recent
+// pointer-free object, or to a small new one.
+//
+//go:noinline
+func (b *gcBatch) allocPointers(size int64) {
+	if len(b.bytes) == 0 {
+		b.allocBytes(8)
+	}
+	target := b.bytes[len(b.bytes)-1]
+	ptrs := make([]*byte, size/8)
+	for i := range ptrs {
+		ptrs[i] = &target[i%len(target)]
+	}
+	b.pointers = append(b.pointers, ptrs)
+}
+
+// retain keeps the objects of b alive for lifetime. The pending timer
+// references b until it fires.
+func (b *gcBatch) retain(lifetime time.Duration) {
+	if lifetime <= 0 || len(b.bytes)+len(b.pointers) == 0 {
+		return
+	}
+	time.AfterFunc(lifetime, func() { runtime.KeepAlive(b) })
+}
@@ -0,0 +1,76 @@
+package main
+
+import (
+	"context"
+	"runtime"
+	"testing"
+	"time"
+)
+
+func Test_gcPressure(t *testing.T) {
+	p := gcPressure{
+		Rate:           10 << 20,
+		Duration:       100 * time.Millisecond,
+		Lifetime:       50 * time.Millisecond,
+		ObjectSize:     1 << 10,
+		PointerDensity: 0.5,
+	}
+	var before, after runtime.MemStats
+	runtime.ReadMemStats(&before)
+	start := time.Now()
+	allocated, objects, err := p.Run(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	runtime.ReadMemStats(&after)
+
+	if got, want := allocated, int64(1<<20); got != want {
+		t.Fatalf("got=%d want=%d", got, want)
+	} else if got, want := objects, 1<<10; got != want {
+		t.Fatalf("got=%d want=%d", got, want)
+	} else if got, want := time.Since(start), p.Duration; got < want {
+		t.Fatalf("got=%s want>=%s", got, want)
+	} else if got := int64(after.TotalAlloc - before.TotalAlloc); got < allocated {
+		t.Fatalf("got=%d want>=%d", got, allocated)
+	}
+
+	for _, p := range []gcPressure{
+		{Rate: -1, ObjectSize: 8},
+		{ObjectSize: 4},
+		{ObjectSize: maxGCPressureObjectSize + 1},
+		{Lifetime: maxGCPressureLifetime + 1, ObjectSize: 8},
+		{Rate: 1 << 40, Duration: time.Second, ObjectSize: 8},
+		{ObjectSize: 8, PointerDensity: 1.5},
+	} {
+		if _, _, err := p.Run(context.Background()); err == nil {
+			t.Fatalf("expected error for %+v", p)
+		}
+	}
+}
+
+func Test_parseByteSize(t *testing.T) {
+	tests := []struct {
+		In      string
+		Want    int64
+		WantErr bool
+	}{
+		{In: "512", Want: 512},
+		{In: "512B", Want: 512},
+		{In: "64KiB", Want: 64 << 10},
+		{In: "1.5GiB", Want: 3 << 29},
+		{In: "2TiB", Want: 2 << 40},
+		{In: "1MB", WantErr: true},
+		{In: "-1KiB", WantErr: true},
+		{In: "", WantErr: true},
+		{In: "1e30", WantErr: true},
+		{In: "8388608TiB", WantErr: true},
+	}
+	for _, test := range tests {
+		got, err := parseByteSize(test.In)
+		if (err != nil) != test.WantErr {
+			t.Fatalf("%q: got err=%v want err=%v", test.In, err, test.WantErr)
+		} else if got != test.Want {
+			t.Fatalf("%q: got=%d want=%d", test.In, got, test.Want)
+		}
+	}
+}
@@ -4,10 +4,11 @@ go 1.16
 
 require (
 	github.com/DataDog/datadog-go v4.8.2+incompatible
-	github.com/go-sql-driver/mysql v1.6.0 // indirect
+	github.com/go-sql-driver/mysql v1.6.0
+	github.com/google/pprof v0.0.0-20210423192551-a2663126120b
 	github.com/jackc/pgx/v4 v4.13.0
 	github.com/julienschmidt/httprouter v1.3.0 // indirect
-	github.com/mattn/go-sqlite3 v1.14.8 // indirect
+	github.com/mattn/go-sqlite3 v1.14.8
 	github.com/nsrip-dd/cgotraceback v0.0.0-20220518170113-75f7f93d1852 // indirect
 	github.com/opentracing/opentracing-go v1.2.0 // indirect
 	gopkg.in/DataDog/dd-trace-go.v1 v1.36.0
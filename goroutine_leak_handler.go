@@ -0,0 +1,263 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// leakedGoroutines keeps track of all goroutines leaked by
+// GoroutineLeakHandler so they can be inspected and released again via
+// GoroutineLeakAdminHandler.
+var leakedGoroutines = &goroutineLeaks{m: map[int]*goroutineLeak{}}
+
+// Leak shapes supported by GoroutineLeakHandler. Each shape blocks in a
+// different function so they can be told apart in goroutine profiles.
+const (
+	leakShapeChan = "chan"
+	leakShapeCond = "cond"
+	leakShapeNet  = "net"
+)
+
+var leakShapes = []string{leakShapeChan, leakShapeCond, leakShapeNet}
+
+// GoroutineLeakHandler leaks goroutines that block forever. The number of
+// goroutines to leak for each shape is given by a query parameter of the same
+// name, e.g. ?chan=10&cond=5&net=1. If no shape is given, a single chan
+// goroutine is leaked.
+type GoroutineLeakHandler struct {
+	DB Store
+}
+
+func (h GoroutineLeakHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if _, ok := auth(h.DB, w, r); !ok {
+		return
+	}
+
+	q := r.URL.Query()
+	counts := map[string]int64{}
+	for _, shape := range leakShapes {
+		n, err := parseIntParam(q.Get(shape))
+		if err != nil {
+			respondErr(w, http.StatusBadRequest, "bad %s: %s\n", shape, err)
+			return
+		}
+		counts[shape] = n
+	}
+	if counts[leakShapeChan]+counts[leakShapeCond]+counts[leakShapeNet] == 0 {
+		counts[leakShapeChan] = 1
+	}
+
+	for _, shape := range leakShapes {
+		for i := int64(0); i < counts[shape]; i++ {
+			if _, err := leakedGoroutines.Leak(shape); err != nil {
+				respondErr(w, http.StatusInternalServerError, "leak %s: %s\n", shape, err)
+				return
+			}
+		}
+	}
+	fmt.Fprintf(w, "leaked goroutines: chan=%d cond=%d net=%d, total %d\n",
+		counts[leakShapeChan],
+		counts[leakShapeCond],
+		counts[leakShapeNet],
+		leakedGoroutines.Len(),
+	)
+}
+
+// GoroutineLeakAdminHandler lists the goroutines leaked by
+// GoroutineLeakHandler on GET, and releases them on POST. The goroutines to
+// release can be narrowed down with the id and shape query parameters.
+type GoroutineLeakAdminHandler struct {
+	DB Store
+}
+
+func (h GoroutineLeakAdminHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if _, ok := auth(h.DB, w, r); !ok {
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		for _, l := range leakedGoroutines.List() {
+			fmt.Fprintf(w, "%d %s %s\n", l.ID, l.Shape, time.Since(l.Created).Round(time.Millisecond))
+		}
+	case http.MethodPost:
+		q := r.URL.Query()
+		id := -1
+		if val := q.Get("id"); val != "" {
+			var err error
+			if id, err = strconv.Atoi(val); err != nil {
+				respondErr(w, http.StatusBadRequest, "bad id: %s\n", err)
+				return
+			}
+		}
+		n := leakedGoroutines.Release(id, q.Get("shape"))
+		fmt.Fprintf(w, "released %d goroutines\n", n)
+	default:
+		respondErr(w, http.StatusMethodNotAllowed, "method not allowed: %s\n", r.Method)
+	}
+}
+
+// goroutineLeaks is a concurrency safe registry of leaked goroutines.
+type goroutineLeaks struct {
+	mu     sync.Mutex
+	nextID int
+	m      map[int]*goroutineLeak
+}
+
+type goroutineLeak struct {
+	ID      int
+	Shape   string
+	Created time.Time
+
+	release func()
+	done    chan struct{}
+}
+
+// Leak starts a new goroutine of the given shape that blocks until it's
+// released.
+func (g *goroutineLeaks) Leak(shape string) (int, error) {
+	l := &goroutineLeak{
+		Shape:   shape,
+		Created: time.Now(),
+		done:    make(chan struct{}),
+	}
+
+	switch shape {
+	case leakShapeChan:
+		ch := make(chan struct{})
+		l.release = func() { close(ch) }
+		go leakChanGoroutine(ch, l.done)
+	case leakShapeCond:
+		var released bool
+		cond := sync.NewCond(&sync.Mutex{})
+		l.release = func() {
+			cond.L.Lock()
+			released = true
+			cond.L.Unlock()
+			cond.Broadcast()
+		}
+		go leakCondGoroutine(cond, &released, l.done)
+	case leakShapeNet:
+		client, server, err := tcpConnPair()
+		if err != nil {
+			return 0, err
+		}
+		l.release = func() {
+			client.Close()
+			server.Close()
+		}
+		go leakNetGoroutine(client, l.done)
+	default:
+		return 0, fmt.Errorf("unknown leak shape: %q", shape)
+	}
+
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	g.nextID++
+	l.ID = g.nextID
+	g.m[l.ID] = l
+	return l.ID, nil
+}
+
+// List returns all leaked goroutines ordered by id.
+func (g *goroutineLeaks) List() []*goroutineLeak {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	list := make([]*goroutineLeak, 0, len(g.m))
+	for _, l := range g.m {
+		list = append(list, l)
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].ID < list[j].ID })
+	return list
+}
+
+// Len returns the number of leaked goroutines.
+func (g *goroutineLeaks) Len() int {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	return len(g.m)
+}
+
+// Release unblocks the leaked goroutines matching the given id and shape and
+// waits for them to exit. An id < 0 or an empty shape matches all goroutines.
+// Release returns the number of released goroutines.
+func (g *goroutineLeaks) Release(id int, shape string) int {
+	g.mu.Lock()
+	var released []*goroutineLeak
+	for _, l := range g.m {
+		if (id < 0 || l.ID == id) && (shape == "" || l.Shape == shape) {
+			released = append(released, l)
+			delete(g.m, l.ID)
+		}
+	}
+	g.mu.Unlock()
+
+	for _, l := range released {
+		l.release()
+		<-l.done
+	}
+	return len(released)
+}
+
+//go:noinline
+func leakChanGoroutine(ch chan struct{}, done chan struct{}) {
+	defer close(done)
+	<-ch
+}
+
+//go:noinline
+func leakCondGoroutine(cond *sync.Cond, released *bool, done chan struct{}) {
+	defer close(done)
+	cond.L.Lock()
+	defer cond.L.Unlock()
+	for !*released {
+		cond.Wait()
+	}
+}
+
+//go:noinline
+func leakNetGoroutine(conn net.Conn, done chan struct{}) {
+	defer close(done)
+	buf := make([]byte, 1)
+	for {
+		if _, err := conn.Read(buf); err != nil {
+			return
+		}
+	}
+}
+
+// tcpConnPair returns both ends of a loopback tcp connection.
+func tcpConnPair() (net.Conn, net.Conn, error) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return nil, nil, err
+	}
+	defer ln.Close()
+
+	type acceptResult struct {
+		conn net.Conn
+		err  error
+	}
+	acceptCh := make(chan acceptResult, 1)
+	go func() {
+		conn, err := ln.Accept()
+		acceptCh <- acceptResult{conn, err}
+	}()
+
+	client, err := net.Dial("tcp", ln.Addr().String())
+	if err != nil {
+		return nil, nil, err
+	}
+	res := <-acceptCh
+	if res.err != nil {
+		client.Close()
+		return nil, nil, res.err
+	}
+	return client, res.conn, nil
+}
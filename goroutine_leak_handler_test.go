@@ -0,0 +1,40 @@
+package main
+
+import (
+	"testing"
+)
+
+func Test_goroutineLeaks(t *testing.T) {
+	g := &goroutineLeaks{m: map[int]*goroutineLeak{}}
+	for _, shape := range []string{leakShapeChan, leakShapeChan, leakShapeCond, leakShapeNet} {
+		if _, err := g.Leak(shape); err != nil {
+			t.Fatalf("%s: %s", shape, err)
+		}
+	}
+	if _, err := g.Leak("foo"); err == nil {
+		t.Fatalf("expected error for unknown shape")
+	}
+
+	list := g.List()
+	if len(list) != 4 {
+		t.Fatalf("got=%d want=%d", len(list), 4)
+	}
+	for i, l := range list {
+		if l.ID != i+1 {
+			t.Fatalf("got=%d want=%d", l.ID, i+1)
+		}
+	}
+
+	if n := g.Release(1, ""); n != 1 {
+		t.Fatalf("got=%d want=%d", n, 1)
+	}
+	if n := g.Release(-1, leakShapeCond); n != 1 {
+		t.Fatalf("got=%d want=%d", n, 1)
+	}
+	if n := g.Release(-1, ""); n != 2 {
+		t.Fatalf("got=%d want=%d", n, 2)
+	}
+	if n := g.Len(); n != 0 {
+		t.Fatalf("got=%d want=%d", n, 0)
+	}
+}
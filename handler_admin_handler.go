@@ -0,0 +1,146 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"time"
+)
+
+// TunableHandler is implemented by handlers whose workload parameters can be
+// changed while the server is running.
+type TunableHandler interface {
+	http.Handler
+	// Params returns the current parameters of the handler. Parameters that
+	// don't apply to the handler are nil.
+	Params() HandlerParams
+	// SetParams updates all non-nil parameters. It returns an error without
+	// changing anything if a parameter doesn't apply to the handler or is
+	// invalid.
+	SetParams(HandlerParams) error
+}
+
+// HandlerParams are the tunable parameters of a handler.
+type HandlerParams struct {
+	CPUDuration   *Duration `json:"cpuDuration,omitempty"`
+	SQLDuration   *Duration `json:"sqlDuration,omitempty"`
+	CGO           *bool     `json:"cgo,omitempty"`
+	PowDifficulty *int      `json:"powDifficulty,omitempty"`
+	Encoder       *string   `json:"encoder,omitempty"`
+}
+
+// HandlerAdminHandler allows reading and updating the parameters of the
+// tunable handlers registered on the router.
+//
+// GET returns the parameters of all handlers as JSON. POST updates the handler
+// given by the path query parameter using the cpuDuration, sqlDuration, cgo,
+// powDifficulty and encoder query parameters, e.g.:
+//
+//	POST /admin/handlers?path=/io-bound&cpuDuration=90ms&sqlDuration=10ms
+type HandlerAdminHandler struct {
+	DB Store
+	// Handlers maps route paths to their handler.
+	Handlers map[string]TunableHandler
+}
+
+// NewHandlerAdminHandler returns a HandlerAdminHandler for all tunable
+// handlers in routes.
+func NewHandlerAdminHandler(db Store, routes []scenarioRoute) HandlerAdminHandler {
+	h := HandlerAdminHandler{DB: db, Handlers: map[string]TunableHandler{}}
+	for _, r := range routes {
+		if th, ok := r.Handler.(TunableHandler); ok {
+			h.Handlers[r.Path] = th
+		}
+	}
+	return h
+}
+
+func (h HandlerAdminHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if _, ok := auth(h.DB, w, r); !ok {
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		h.writeParams(w)
+	case http.MethodPost:
+		q := r.URL.Query()
+		path := q.Get("path")
+		th, ok := h.Handlers[path]
+		if !ok {
+			respondErr(w, http.StatusNotFound, "no tunable handler for path: %q\n", path)
+			return
+		}
+		p, err := parseHandlerParams(q.Get)
+		if err != nil {
+			respondErr(w, http.StatusBadRequest, "%s\n", err)
+			return
+		}
+		if err := th.SetParams(p); err != nil {
+			respondErr(w, http.StatusBadRequest, "%s: %s\n", path, err)
+			return
+		}
+		h.writeParams(w)
+	default:
+		respondErr(w, http.StatusMethodNotAllowed, "method not allowed: %s\n", r.Method)
+	}
+}
+
+func (h HandlerAdminHandler) writeParams(w http.ResponseWriter) {
+	var paths []string
+	for path := range h.Handlers {
+		paths = append(paths, path)
+	}
+	sort.Strings(paths)
+
+	type handlerInfo struct {
+		Path string `json:"path"`
+		HandlerParams
+	}
+	infos := []handlerInfo{}
+	for _, path := range paths {
+		infos = append(infos, handlerInfo{Path: path, HandlerParams: h.Handlers[path].Params()})
+	}
+	w.Header().Set("Content-Type", "application/json")
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	enc.Encode(infos)
+}
+
+// parseHandlerParams parses the HandlerParams from the values returned by
+// get, e.g. url.Values.Get. Missing values are left nil.
+func parseHandlerParams(get func(string) string) (p HandlerParams, err error) {
+	for name, dst := range map[string]**Duration{
+		"cpuDuration": &p.CPUDuration,
+		"sqlDuration": &p.SQLDuration,
+	} {
+		if val := get(name); val != "" {
+			d, err := time.ParseDuration(val)
+			if err != nil {
+				return p, fmt.Errorf("bad %s: %w", name, err)
+			}
+			dd := Duration(d)
+			*dst = &dd
+		}
+	}
+	if val := get("cgo"); val != "" {
+		cgo, err := strconv.ParseBool(val)
+		if err != nil {
+			return p, fmt.Errorf("bad cgo: %w", err)
+		}
+		p.CGO = &cgo
+	}
+	if val := get("powDifficulty"); val != "" {
+		difficulty, err := strconv.Atoi(val)
+		if err != nil {
+			return p, fmt.Errorf("bad powDifficulty: %w", err)
+		}
+		p.PowDifficulty = &difficulty
+	}
+	if val := get("encoder"); val != "" {
+		p.Encoder = &val
+	}
+	return p, nil
+}
@@ -0,0 +1,80 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+)
+
+func Test_HandlerAdminHandler(t *testing.T) {
+	store, err := NewMemoryStore("fixed")
+	if err != nil {
+		t.Fatal(err)
+	}
+	posts := &PostsHandler{DB: store, CPUDuration: 10 * time.Millisecond, SQLDuration: 90 * time.Millisecond}
+	tx := &TransactionHandler{DB: store, PowDifficultiy: 4}
+	h := NewHandlerAdminHandler(store, []scenarioRoute{
+		{Method: "GET", Path: "/io-bound", Handler: posts},
+		{Method: "GET", Path: "/transaction", Handler: tx},
+		{Method: "POST", Path: "/transaction", Handler: tx},
+		{Method: "GET", Path: "/goroutine-leak", Handler: GoroutineLeakHandler{DB: store}},
+	})
+	if len(h.Handlers) != 2 {
+		t.Fatalf("got=%d want=%d", len(h.Handlers), 2)
+	}
+
+	do := func(method string, q url.Values) *httptest.ResponseRecorder {
+		q.Set("key", seedAPIKey)
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, httptest.NewRequest(method, "/admin/handlers?"+q.Encode(), nil))
+		return rec
+	}
+
+	rec := do("POST", url.Values{"path": {"/io-bound"}, "cpuDuration": {"90ms"}, "sqlDuration": {"10ms"}, "cgo": {"true"}, "encoder": {"pooled"}})
+	if rec.Code != http.StatusOK {
+		t.Fatalf("got=%d want=%d: %s", rec.Code, http.StatusOK, rec.Body)
+	}
+	if posts.CPUDuration != 90*time.Millisecond || posts.SQLDuration != 10*time.Millisecond || !posts.CGO || posts.Encoder != "pooled" {
+		t.Fatalf("params not updated: %+v", posts.Params())
+	}
+
+	rec = do("POST", url.Values{"path": {"/transaction"}, "powDifficulty": {"2"}})
+	if rec.Code != http.StatusOK {
+		t.Fatalf("got=%d want=%d: %s", rec.Code, http.StatusOK, rec.Body)
+	} else if tx.PowDifficultiy != 2 {
+		t.Fatalf("got=%d want=%d", tx.PowDifficultiy, 2)
+	}
+
+	for _, q := range []url.Values{
+		{"path": {"/transaction"}, "cpuDuration": {"1ms"}},
+		{"path": {"/transaction"}, "powDifficulty": {"41"}},
+		{"path": {"/transaction"}, "powDifficulty": {"7"}},
+		{"path": {"/io-bound"}, "cpuDuration": {"-1ms"}},
+		{"path": {"/io-bound"}, "cgo": {"maybe"}},
+		{"path": {"/io-bound"}, "encoder": {"xml"}},
+		{"path": {"/transaction"}, "encoder": {"current"}},
+	} {
+		if rec := do("POST", q); rec.Code != http.StatusBadRequest {
+			t.Fatalf("%v: got=%d want=%d", q, rec.Code, http.StatusBadRequest)
+		}
+	}
+	if rec := do("POST", url.Values{"path": {"/goroutine-leak"}}); rec.Code != http.StatusNotFound {
+		t.Fatalf("got=%d want=%d", rec.Code, http.StatusNotFound)
+	}
+
+	rec = do("GET", url.Values{})
+	var got []struct {
+		Path string
+		HandlerParams
+	}
+	if err := json.Unmarshal(rec.Body.Bytes(), &got); err != nil {
+		t.Fatal(err)
+	} else if len(got) != 2 || got[0].Path != "/io-bound" || got[1].Path != "/transaction" {
+		t.Fatalf("unexpected response: %s", rec.Body)
+	} else if *got[0].CPUDuration != Duration(90*time.Millisecond) || *got[1].PowDifficulty != 2 {
+		t.Fatalf("unexpected response: %s", rec.Body)
+	}
+}
@@ -0,0 +1,404 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math"
+	"math/rand"
+	"net/http"
+	"net/url"
+	"os"
+	"os/signal"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"text/tabwriter"
+	"time"
+)
+
+// defaultLoadMix is the endpoint mix used by the load subcommand if no -mix
+// flag is given.
+const defaultLoadMix = "io-bound=1,cpu-bound=1,cgo-cpu-bound=1,transaction=1"
+
+// maxLoadRPS is the highest request rate of a load phase. Higher rates can't
+// be paced by a ticker, use an rps of 0 to send as fast as possible instead.
+const maxLoadRPS = 1e6
+
+// runLoad implements the load subcommand which drives traffic against a
+// running go-prof-app instance and reports the results.
+func runLoad(args []string) error {
+	var (
+		fs           = flag.NewFlagSet("load", flag.ExitOnError)
+		addrF        = fs.String("addr", "http://localhost:8080", "Base url of the go-prof-app to load")
+		keyF         = fs.String("key", seedAPIKey, "API key to use for requests")
+		rpsF         = fs.Float64("rps", 10, "Requests per second across all endpoints, 0 for as fast as possible")
+		concurrencyF = fs.Int("concurrency", 10, "Max number of concurrent requests")
+		durationF    = fs.Duration("duration", 30*time.Second, "Duration of the load test")
+		mixF         = fs.String("mix", defaultLoadMix, "Comma separated list of endpoint=weight pairs")
+		scenarioF    = fs.String("scenario", "", "Scenario file to take the load schedule from, overrides -rps, -duration and -mix")
+	)
+	fs.Parse(args)
+
+	if *concurrencyF < 1 {
+		return fmt.Errorf("-concurrency must be at least 1: %d", *concurrencyF)
+	}
+	var phases []loadPhase
+	if *scenarioF != "" {
+		scenario, err := LoadScenario(*scenarioF)
+		if err != nil {
+			return err
+		}
+		if phases, err = scenario.LoadPhases(); err != nil {
+			return err
+		}
+		fmt.Printf("Sending %d load phases from %s to %s\n", len(phases), *scenarioF, *addrF)
+	} else {
+		mix, err := parseLoadMix(*mixF)
+		if err != nil {
+			return err
+		} else if err := validateLoadRPS(*rpsF); err != nil {
+			return fmt.Errorf("bad -rps: %w", err)
+		}
+		phases = append(phases, loadPhase{RPS: *rpsF, Duration: *durationF, Mix: mix})
+		fmt.Printf("Sending %v rps to %s for %s with %s\n", *rpsF, *addrF, *durationF, *mixF)
+	}
+
+	ctx, cancel := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer cancel()
+
+	lg := &loadGenerator{
+		BaseURL:     *addrF,
+		APIKey:      *keyF,
+		Concurrency: *concurrencyF,
+		Client:      &http.Client{Timeout: 30 * time.Second},
+	}
+	report := lg.Run(ctx, phases...)
+	report.Print(os.Stdout)
+	return nil
+}
+
+// loadTarget is an endpoint hit by the load generator with a relative weight.
+type loadTarget struct {
+	Endpoint string
+	Weight   float64
+}
+
+// parseLoadMix parses a comma separated list of endpoint=weight pairs. The
+// weight defaults to 1 if omitted.
+func parseLoadMix(val string) ([]loadTarget, error) {
+	var mix []loadTarget
+	for _, part := range strings.Split(val, ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+		t := loadTarget{Weight: 1}
+		t.Endpoint = part
+		if i := strings.IndexByte(part, '='); i >= 0 {
+			t.Endpoint = part[:i]
+			w, err := strconv.ParseFloat(part[i+1:], 64)
+			if err != nil {
+				return nil, fmt.Errorf("bad weight for %q: %q", t.Endpoint, part[i+1:])
+			}
+			t.Weight = w
+		}
+		t.Endpoint = "/" + strings.TrimPrefix(t.Endpoint, "/")
+		mix = append(mix, t)
+	}
+	if err := validateLoadMix(mix); err != nil {
+		return nil, err
+	}
+	return mix, nil
+}
+
+// validateLoadMix returns an error if mix is empty, has a negative or
+// non-finite weight, or no positive weight at all.
+func validateLoadMix(mix []loadTarget) error {
+	if len(mix) == 0 {
+		return fmt.Errorf("empty endpoint mix")
+	}
+	var total float64
+	for _, t := range mix {
+		if t.Weight < 0 || math.IsInf(t.Weight, 0) || math.IsNaN(t.Weight) {
+			return fmt.Errorf("bad weight for %q: %v", t.Endpoint, t.Weight)
+		}
+		total += t.Weight
+	}
+	if total == 0 {
+		return fmt.Errorf("endpoint mix has no positive weight")
+	}
+	return nil
+}
+
+// validateLoadRPS returns an error if rps isn't between 0 and maxLoadRPS.
+func validateLoadRPS(rps float64) error {
+	if !(rps >= 0 && rps <= maxLoadRPS) {
+		return fmt.Errorf("rps must be between 0 and %g: %v", maxLoadRPS, rps)
+	}
+	return nil
+}
+
+// pickLoadTarget returns a random endpoint from mix according to the weights.
+func pickLoadTarget(mix []loadTarget, r *rand.Rand) string {
+	var total float64
+	for _, t := range mix {
+		total += t.Weight
+	}
+	n := r.Float64() * total
+	for _, t := range mix {
+		if n < t.Weight {
+			return t.Endpoint
+		}
+		n -= t.Weight
+	}
+	return mix[len(mix)-1].Endpoint
+}
+
+// loadPhase describes a period of constant load.
+type loadPhase struct {
+	RPS      float64
+	Duration time.Duration
+	Mix      []loadTarget
+}
+
+// loadGenerator sends requests to a go-prof-app instance.
+type loadGenerator struct {
+	BaseURL     string
+	APIKey      string
+	Concurrency int
+	Client      *http.Client
+}
+
+// Run executes the given phases one after another and returns a report
+// covering all of them. Run returns early if ctx is canceled.
+func (lg *loadGenerator) Run(ctx context.Context, phases ...loadPhase) *loadReport {
+	report := newLoadReport()
+	jobs := make(chan string)
+
+	var wg sync.WaitGroup
+	for i := 0; i < lg.Concurrency; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for endpoint := range jobs {
+				start := time.Now()
+				err := lg.request(ctx, endpoint)
+				report.Record(endpoint, time.Since(start), err)
+			}
+		}()
+	}
+
+	r := rand.New(rand.NewSource(time.Now().UnixNano()))
+	for _, phase := range phases {
+		lg.runPhase(ctx, phase, r, jobs)
+	}
+	close(jobs)
+	wg.Wait()
+	return report
+}
+
+func (lg *loadGenerator) runPhase(ctx context.Context, phase loadPhase, r *rand.Rand, jobs chan<- string) {
+	ctx, cancel := context.WithTimeout(ctx, phase.Duration)
+	defer cancel()
+
+	var tick <-chan time.Time
+	if phase.RPS > 0 {
+		ticker := time.NewTicker(time.Duration(float64(time.Second) / phase.RPS))
+		defer ticker.Stop()
+		tick = ticker.C
+	}
+
+	for {
+		if tick != nil {
+			select {
+			case <-tick:
+			case <-ctx.Done():
+				return
+			}
+		}
+		select {
+		case jobs <- pickLoadTarget(phase.Mix, r):
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+func (lg *loadGenerator) request(ctx context.Context, endpoint string) error {
+	q := url.Values{"key": {lg.APIKey}}
+	if endpoint == "/transaction" {
+		q.Set("data", strconv.FormatInt(rand.Int63(), 36))
+	}
+	req, err := http.NewRequestWithContext(ctx, "GET", strings.TrimSuffix(lg.BaseURL, "/")+endpoint+"?"+q.Encode(), nil)
+	if err != nil {
+		return err
+	}
+	res, err := lg.Client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	if _, err := io.Copy(ioutil.Discard, res.Body); err != nil {
+		return err
+	}
+	if res.StatusCode != http.StatusOK {
+		return fmt.Errorf("http status %d", res.StatusCode)
+	}
+	return nil
+}
+
+// loadHistogramBounds are the upper bounds of the latency histogram buckets
+// shown in load reports.
+var loadHistogramBounds = []time.Duration{
+	1 * time.Millisecond,
+	2 * time.Millisecond,
+	5 * time.Millisecond,
+	10 * time.Millisecond,
+	20 * time.Millisecond,
+	50 * time.Millisecond,
+	100 * time.Millisecond,
+	200 * time.Millisecond,
+	500 * time.Millisecond,
+	1 * time.Second,
+	2 * time.Second,
+	5 * time.Second,
+}
+
+// loadReport collects the latencies and errors of a load test.
+type loadReport struct {
+	mu        sync.Mutex
+	start     time.Time
+	latencies map[string][]time.Duration
+	errors    map[string]map[string]int
+}
+
+func newLoadReport() *loadReport {
+	return &loadReport{
+		start:     time.Now(),
+		latencies: map[string][]time.Duration{},
+		errors:    map[string]map[string]int{},
+	}
+}
+
+// Record adds the result of a single request to the report.
+func (lr *loadReport) Record(endpoint string, d time.Duration, err error) {
+	lr.mu.Lock()
+	defer lr.mu.Unlock()
+
+	if err != nil {
+		if lr.errors[endpoint] == nil {
+			lr.errors[endpoint] = map[string]int{}
+		}
+		lr.errors[endpoint][err.Error()]++
+		return
+	}
+	lr.latencies[endpoint] = append(lr.latencies[endpoint], d)
+}
+
+// Print writes a human readable summary of the report to w.
+func (lr *loadReport) Print(w io.Writer) {
+	lr.mu.Lock()
+	defer lr.mu.Unlock()
+
+	endpoints := map[string]bool{}
+	var all []time.Duration
+	for endpoint, ds := range lr.latencies {
+		endpoints[endpoint] = true
+		all = append(all, ds...)
+	}
+	for endpoint := range lr.errors {
+		endpoints[endpoint] = true
+	}
+	var names []string
+	for endpoint := range endpoints {
+		names = append(names, endpoint)
+	}
+	sort.Strings(names)
+
+	elapsed := time.Since(lr.start)
+	fmt.Fprintf(w, "\nRequests by endpoint (%s):\n", elapsed.Round(time.Millisecond))
+	tw := tabwriter.NewWriter(w, 0, 8, 2, ' ', tabwriter.AlignRight)
+	fmt.Fprintf(tw, "endpoint\tok\terrors\trps\tp50\tp90\tp99\tmax\t\n")
+	writeRow := func(name string, ds []time.Duration, errs int) {
+		sortDurations(ds)
+		fmt.Fprintf(tw, "%s\t%d\t%d\t%.1f\t%s\t%s\t%s\t%s\t\n",
+			name,
+			len(ds),
+			errs,
+			float64(len(ds)+errs)/elapsed.Seconds(),
+			durationPercentile(ds, 0.5),
+			durationPercentile(ds, 0.9),
+			durationPercentile(ds, 0.99),
+			durationPercentile(ds, 1),
+		)
+	}
+	var totalErrs int
+	for _, name := range names {
+		var errs int
+		for _, n := range lr.errors[name] {
+			errs += n
+		}
+		totalErrs += errs
+		writeRow(name, lr.latencies[name], errs)
+	}
+	writeRow("total", all, totalErrs)
+	tw.Flush()
+
+	fmt.Fprintf(w, "\nLatency histogram:\n")
+	counts := latencyHistogram(all, loadHistogramBounds)
+	for i, count := range counts {
+		label := "+Inf"
+		if i < len(loadHistogramBounds) {
+			label = loadHistogramBounds[i].String()
+		}
+		var bar string
+		if len(all) > 0 {
+			bar = strings.Repeat("#", count*50/len(all))
+		}
+		fmt.Fprintf(w, "  <= %6s %8d %s\n", label, count, bar)
+	}
+
+	if totalErrs > 0 {
+		fmt.Fprintf(w, "\nErrors:\n")
+		for _, name := range names {
+			for msg, n := range lr.errors[name] {
+				fmt.Fprintf(w, "  %s: %s: %d\n", name, msg, n)
+			}
+		}
+	}
+}
+
+// latencyHistogram returns the number of durations falling into each of the
+// buckets defined by the given upper bounds. The last count is for durations
+// exceeding the largest bound.
+func latencyHistogram(ds []time.Duration, bounds []time.Duration) []int {
+	counts := make([]int, len(bounds)+1)
+	for _, d := range ds {
+		i := sort.Search(len(bounds), func(i int) bool { return d <= bounds[i] })
+		counts[i]++
+	}
+	return counts
+}
+
+func sortDurations(ds []time.Duration) {
+	sort.Slice(ds, func(i, j int) bool { return ds[i] < ds[j] })
+}
+
+// durationPercentile returns the p-th percentile of the sorted durations
+// ds using the nearest-rank method.
+func durationPercentile(ds []time.Duration, p float64) time.Duration {
+	if len(ds) == 0 {
+		return 0
+	}
+	i := int(math.Ceil(p*float64(len(ds)))) - 1
+	if i < 0 {
+		i = 0
+	} else if i >= len(ds) {
+		i = len(ds) - 1
+	}
+	return ds[i].Round(time.Microsecond)
+}
@@ -0,0 +1,128 @@
+package main
+
+import (
+	"context"
+	"math"
+	"math/rand"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func Test_parseLoadMix(t *testing.T) {
+	tests := []struct {
+		In      string
+		Want    []loadTarget
+		WantErr bool
+	}{
+		{
+			In:   "io-bound=2, /cpu-bound",
+			Want: []loadTarget{{"/io-bound", 2}, {"/cpu-bound", 1}},
+		},
+		{In: "", WantErr: true},
+		{In: "io-bound=x", WantErr: true},
+		{In: "io-bound=-1", WantErr: true},
+		{In: "io-bound=NaN", WantErr: true},
+		{In: "io-bound=0,cpu-bound=0", WantErr: true},
+	}
+	for _, test := range tests {
+		got, err := parseLoadMix(test.In)
+		if test.WantErr {
+			if err == nil {
+				t.Fatalf("%q: expected error", test.In)
+			}
+			continue
+		} else if err != nil {
+			t.Fatalf("%q: %s", test.In, err)
+		}
+		if !reflect.DeepEqual(got, test.Want) {
+			t.Fatalf("%q: got=%v want=%v", test.In, got, test.Want)
+		}
+	}
+}
+
+func Test_validateLoadRPS(t *testing.T) {
+	for rps, wantErr := range map[float64]bool{0: false, 10: false, maxLoadRPS: false, -1: true, 2e9: true, math.NaN(): true} {
+		if err := validateLoadRPS(rps); (err != nil) != wantErr {
+			t.Fatalf("%v: got err=%v want err=%v", rps, err, wantErr)
+		}
+	}
+}
+
+func Test_pickLoadTarget(t *testing.T) {
+	mix := []loadTarget{{"/a", 3}, {"/b", 1}, {"/c", 0}}
+	r := rand.New(rand.NewSource(1))
+	counts := map[string]int{}
+	for i := 0; i < 10000; i++ {
+		counts[pickLoadTarget(mix, r)]++
+	}
+	if counts["/c"] != 0 {
+		t.Fatalf("got=%d want=%d", counts["/c"], 0)
+	}
+	if ratio := float64(counts["/a"]) / float64(counts["/b"]); ratio < 2.7 || ratio > 3.3 {
+		t.Fatalf("got=%f want=%f", ratio, 3.0)
+	}
+}
+
+func Test_latencyHistogram(t *testing.T) {
+	bounds := []time.Duration{time.Millisecond, 10 * time.Millisecond}
+	ds := []time.Duration{0, time.Millisecond, 2 * time.Millisecond, time.Second}
+	got := latencyHistogram(ds, bounds)
+	if want := []int{2, 1, 1}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("got=%v want=%v", got, want)
+	}
+}
+
+func Test_durationPercentile(t *testing.T) {
+	var ds []time.Duration
+	for i := 1; i <= 100; i++ {
+		ds = append(ds, time.Duration(i)*time.Millisecond)
+	}
+	tests := []struct {
+		P    float64
+		Want time.Duration
+	}{
+		{0, 1 * time.Millisecond},
+		{0.5, 50 * time.Millisecond},
+		{0.99, 99 * time.Millisecond},
+		{1, 100 * time.Millisecond},
+	}
+	for _, test := range tests {
+		if got := durationPercentile(ds, test.P); got != test.Want {
+			t.Fatalf("%f: got=%s want=%s", test.P, got, test.Want)
+		}
+	}
+}
+
+func Test_loadGenerator(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Query().Get("key") != seedAPIKey || r.URL.Path == "/fail" {
+			w.WriteHeader(http.StatusInternalServerError)
+		}
+	}))
+	defer server.Close()
+
+	lg := &loadGenerator{
+		BaseURL:     server.URL,
+		APIKey:      seedAPIKey,
+		Concurrency: 4,
+		Client:      server.Client(),
+	}
+	mix := []loadTarget{{"/ok", 1}, {"/fail", 1}}
+	report := lg.Run(context.Background(), loadPhase{RPS: 200, Duration: 250 * time.Millisecond, Mix: mix})
+	if len(report.latencies["/ok"]) == 0 {
+		t.Fatalf("expected successful requests")
+	}
+	if report.errors["/fail"]["http status 500"] == 0 {
+		t.Fatalf("expected failed requests")
+	}
+
+	var out strings.Builder
+	report.Print(&out)
+	if !strings.Contains(out.String(), "Latency histogram") {
+		t.Fatalf("unexpected report: %s", out.String())
+	}
+}
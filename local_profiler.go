@@ -0,0 +1,137 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"runtime/pprof"
+	"sort"
+	"time"
+
+	"gopkg.in/DataDog/dd-trace-go.v1/profiler"
+)
+
+// localProfiler periodically writes pprof files to Dir, mirroring what the
+// dd-trace-go profiler uploads. Every Period, it records a CPU profile for
+// CPUDuration and then writes the other profile Types. Unlike the uploaded
+// profiles, the heap, block and mutex profiles are the cumulative ones
+// written by runtime/pprof, use pprof -diff_base to compare two of them.
+type localProfiler struct {
+	Dir         string
+	Types       []profiler.ProfileType
+	Period      time.Duration
+	CPUDuration time.Duration
+	// Keep is the number of files to keep per profile type, 0 keeps all.
+	Keep int
+	// MaxAge is the age after which files are removed, 0 keeps them forever.
+	MaxAge time.Duration
+}
+
+// Run collects profiles until ctx is done.
+func (p *localProfiler) Run(ctx context.Context) {
+	ticker := time.NewTicker(p.Period)
+	defer ticker.Stop()
+	for {
+		if err := p.collect(ctx); err != nil {
+			log.Printf("Local profiler: %s", err)
+		}
+		if err := p.prune(); err != nil {
+			log.Printf("Local profiler: failed to remove old profiles: %s", err)
+		}
+
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// collect writes one profile of every type. The CPU profile is cut short if
+// ctx is done.
+func (p *localProfiler) collect(ctx context.Context) error {
+	if err := os.MkdirAll(p.Dir, 0755); err != nil {
+		return err
+	}
+	start := time.Now().UTC().Format("20060102-150405.000")
+	for _, t := range p.Types {
+		f, err := os.Create(filepath.Join(p.Dir, fmt.Sprintf("%s-%s.pprof", t, start)))
+		if err != nil {
+			return err
+		}
+		switch t {
+		case profiler.CPUProfile:
+			err = p.cpuProfile(ctx, f)
+		default:
+			if prof := pprof.Lookup(t.String()); prof == nil {
+				err = fmt.Errorf("unknown profile: %s", t)
+			} else {
+				err = prof.WriteTo(f, 0)
+			}
+		}
+		if closeErr := f.Close(); err == nil {
+			err = closeErr
+		}
+		if err != nil {
+			os.Remove(f.Name())
+			return fmt.Errorf("%s profile: %w", t, err)
+		}
+	}
+	return nil
+}
+
+func (p *localProfiler) cpuProfile(ctx context.Context, f *os.File) error {
+	// Fails if somebody else is profiling, e.g. /debug/pprof/profile.
+	if err := pprof.StartCPUProfile(f); err != nil {
+		return err
+	}
+	timer := time.NewTimer(p.CPUDuration)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+	case <-ctx.Done():
+	}
+	pprof.StopCPUProfile()
+	return nil
+}
+
+// prune removes the files exceeding Keep or MaxAge for every profile type.
+func (p *localProfiler) prune() error {
+	for _, t := range p.Types {
+		pattern := filepath.Join(p.Dir, t.String()+"-*.pprof")
+		if err := pruneFiles(pattern, p.Keep, p.MaxAge); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// pruneFiles removes the files matching pattern that are older than maxAge,
+// as well as all but the keep last ones by name. A keep or maxAge of 0
+// disables the respective limit. The names must sort chronologically, e.g.
+// by starting with a timestamp after a common prefix.
+func pruneFiles(pattern string, keep int, maxAge time.Duration) error {
+	paths, err := filepath.Glob(pattern)
+	if err != nil {
+		return err
+	}
+	sort.Strings(paths)
+	for i, path := range paths {
+		remove := keep > 0 && i < len(paths)-keep
+		if !remove && maxAge > 0 {
+			info, err := os.Stat(path)
+			if err != nil {
+				return err
+			}
+			remove = time.Since(info.ModTime()) > maxAge
+		}
+		if remove {
+			if err := os.Remove(path); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
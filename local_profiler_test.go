@@ -0,0 +1,65 @@
+package main
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"gopkg.in/DataDog/dd-trace-go.v1/profiler"
+)
+
+func Test_localProfiler(t *testing.T) {
+	dir := t.TempDir()
+	p := &localProfiler{
+		Dir:         dir,
+		Types:       []profiler.ProfileType{profiler.CPUProfile, profiler.HeapProfile, profiler.BlockProfile, profiler.MutexProfile, profiler.GoroutineProfile},
+		Period:      100 * time.Millisecond,
+		CPUDuration: 50 * time.Millisecond,
+		Keep:        2,
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 450*time.Millisecond)
+	defer cancel()
+	p.Run(ctx)
+
+	for _, name := range []string{"cpu", "heap", "block", "mutex", "goroutine"} {
+		paths, err := filepath.Glob(filepath.Join(dir, name+"-*.pprof"))
+		if err != nil {
+			t.Fatal(err)
+		} else if len(paths) != 2 {
+			t.Fatalf("%s: got=%d want=%d", name, len(paths), 2)
+		}
+		data, err := os.ReadFile(paths[0])
+		if err != nil {
+			t.Fatal(err)
+		} else if len(data) < 2 || data[0] != 0x1f || data[1] != 0x8b {
+			t.Fatalf("%s: not a gzipped pprof file", name)
+		}
+	}
+}
+
+func Test_pruneFiles(t *testing.T) {
+	dir := t.TempDir()
+	for _, name := range []string{"cpu-1.pprof", "cpu-2.pprof", "cpu-3.pprof", "heap-1.pprof"} {
+		if err := os.WriteFile(filepath.Join(dir, name), nil, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	old := time.Now().Add(-time.Hour)
+	if err := os.Chtimes(filepath.Join(dir, "cpu-3.pprof"), old, old); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := pruneFiles(filepath.Join(dir, "cpu-*.pprof"), 2, time.Minute); err != nil {
+		t.Fatal(err)
+	}
+	paths, err := filepath.Glob(filepath.Join(dir, "*"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{filepath.Join(dir, "cpu-2.pprof"), filepath.Join(dir, "heap-1.pprof")}
+	if len(paths) != len(want) || paths[0] != want[0] || paths[1] != want[1] {
+		t.Fatalf("got=%v want=%v", paths, want)
+	}
+}
@@ -0,0 +1,153 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// Locks shared by all LockContentionHandler requests, so concurrent requests
+// contend with each other as well.
+var (
+	contendedMutex   sync.Mutex
+	mutexCounter     int // protected by contendedMutex
+	contendedRWMutex sync.RWMutex
+	rwMutexCounter   int // protected by contendedRWMutex
+)
+
+// LockContentionHandler makes many goroutines fight over a shared lock to
+// produce data for the mutex and block profiles.
+//
+// The following query parameters are supported and override the defaults
+// given by the handler fields:
+//
+//	lock:       mutex or rwmutex (default: mutex)
+//	goroutines: Number of goroutines contending for the lock
+//	iterations: Number of times each goroutine acquires the lock
+//	hold:       Duration of the critical section, e.g. 100us
+//	writes:     Percentage of rwmutex acquisitions that are writes (default: 10)
+type LockContentionHandler struct {
+	DB              Store
+	Goroutines      int
+	Iterations      int
+	CriticalSection time.Duration
+}
+
+func (h LockContentionHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if _, ok := auth(h.DB, w, r); !ok {
+		return
+	}
+
+	q := r.URL.Query()
+	c := lockContention{
+		Lock:            q.Get("lock"),
+		Goroutines:      h.Goroutines,
+		Iterations:      h.Iterations,
+		CriticalSection: h.CriticalSection,
+		WritePercent:    10,
+	}
+	if c.Lock == "" {
+		c.Lock = "mutex"
+	}
+	for name, dst := range map[string]*int{
+		"goroutines": &c.Goroutines,
+		"iterations": &c.Iterations,
+		"writes":     &c.WritePercent,
+	} {
+		if val := q.Get(name); val != "" {
+			n, err := strconv.Atoi(val)
+			if err != nil || n < 0 {
+				respondErr(w, http.StatusBadRequest, "bad %s: %q\n", name, val)
+				return
+			}
+			*dst = n
+		}
+	}
+	if val := q.Get("hold"); val != "" {
+		d, err := time.ParseDuration(val)
+		if err != nil {
+			respondErr(w, http.StatusBadRequest, "bad hold: %s\n", err)
+			return
+		}
+		c.CriticalSection = d
+	}
+
+	start := time.Now()
+	if err := c.Run(); err != nil {
+		respondErr(w, http.StatusBadRequest, "%s\n", err)
+		return
+	}
+	fmt.Fprintf(w, "%d goroutines acquired %s %d times each in %s\n", c.Goroutines, c.Lock, c.Iterations, time.Since(start))
+}
+
+type lockContention struct {
+	Lock            string
+	Goroutines      int
+	Iterations      int
+	CriticalSection time.Duration
+	WritePercent    int
+}
+
+// Run starts c.Goroutines goroutines that each acquire the shared lock
+// c.Iterations times and waits for them to finish.
+func (c lockContention) Run() error {
+	var contend func(i int)
+	switch c.Lock {
+	case "mutex":
+		contend = func(int) { mutexCriticalSection(c.CriticalSection) }
+	case "rwmutex":
+		contend = func(i int) {
+			if i%100 < c.WritePercent {
+				rwMutexWriteCriticalSection(c.CriticalSection)
+			} else {
+				rwMutexReadCriticalSection(c.CriticalSection)
+			}
+		}
+	default:
+		return fmt.Errorf("unknown lock type: %q", c.Lock)
+	}
+
+	var wg sync.WaitGroup
+	for g := 0; g < c.Goroutines; g++ {
+		wg.Add(1)
+		go func(g int) {
+			defer wg.Done()
+			for i := 0; i < c.Iterations; i++ {
+				contend(g*c.Iterations + i)
+			}
+		}(g)
+	}
+	wg.Wait()
+	return nil
+}
+
+//go:noinline
+func mutexCriticalSection(d time.Duration) {
+	contendedMutex.Lock()
+	defer contendedMutex.Unlock()
+	mutexCounter++
+	spin(d)
+}
+
+//go:noinline
+func rwMutexWriteCriticalSection(d time.Duration) {
+	contendedRWMutex.Lock()
+	defer contendedRWMutex.Unlock()
+	rwMutexCounter++
+	spin(d)
+}
+
+//go:noinline
+func rwMutexReadCriticalSection(d time.Duration) {
+	contendedRWMutex.RLock()
+	defer contendedRWMutex.RUnlock()
+	spin(d)
+}
+
+// spin burns CPU for the given duration while holding a lock.
+func spin(d time.Duration) {
+	for start := time.Now(); time.Since(start) < d; {
+	}
+}
@@ -0,0 +1,31 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func Test_lockContention(t *testing.T) {
+	before := mutexCounter
+	c := lockContention{Lock: "mutex", Goroutines: 8, Iterations: 50, CriticalSection: time.Microsecond}
+	if err := c.Run(); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := mutexCounter-before, 8*50; got != want {
+		t.Fatalf("got=%d want=%d", got, want)
+	}
+
+	before = rwMutexCounter
+	c = lockContention{Lock: "rwmutex", Goroutines: 4, Iterations: 100, WritePercent: 10}
+	if err := c.Run(); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := rwMutexCounter-before, 4*10; got != want {
+		t.Fatalf("got=%d want=%d", got, want)
+	}
+
+	c = lockContention{Lock: "foo"}
+	if err := c.Run(); err == nil {
+		t.Fatalf("expected error for unknown lock type")
+	}
+}
@@ -1,7 +1,7 @@
 package main
 
 import (
-	"database/sql"
+	"context"
 	_ "embed"
 	"flag"
 	"fmt"
@@ -10,16 +10,18 @@ import (
 	"net/http"
 	"os"
 	"os/signal"
-	"regexp"
 	"runtime"
+	"runtime/debug"
 	"runtime/metrics"
 	"runtime/trace"
+	"sort"
+	"strconv"
 	"strings"
+	"sync"
+	"syscall"
 	"time"
 
 	"github.com/DataDog/datadog-go/statsd"
-	"github.com/jackc/pgx/v4/stdlib"
-	sqltrace "gopkg.in/DataDog/dd-trace-go.v1/contrib/database/sql"
 	httptrace "gopkg.in/DataDog/dd-trace-go.v1/contrib/julienschmidt/httprouter"
 	"gopkg.in/DataDog/dd-trace-go.v1/ddtrace/tracer"
 	"gopkg.in/DataDog/dd-trace-go.v1/profiler"
@@ -32,6 +34,17 @@ var (
 )
 
 func main() {
+	run := run
+	if len(os.Args) > 1 {
+		switch os.Args[1] {
+		case "load":
+			run = func() error { return runLoad(os.Args[2:]) }
+		case "verify":
+			run = func() error { return runVerify(os.Args[2:]) }
+		case "pow-client":
+			run = func() error { return runPowClient(os.Args[2:]) }
+		}
+	}
 	if err := run(); err != nil {
 		fmt.Fprintln(os.Stderr, err)
 		os.Exit(1)
@@ -58,15 +71,40 @@ func run() error {
 
 		addrF          = flag.String("addr", "localhost:8080", "Listen addr for http server")
 		maxConnsF      = flag.Int("maxConns", 20, "Max number of database connections.")
+		dbDriverF      = flag.String("db.driver", "postgres", "Database backend to use: "+strings.Join(append(dialectNames(), "memory"), ", "))
+		dbDSNF         = flag.String("db.dsn", "", "Data source name for the database, defaults to a driver specific value")
+		dbLatencyF     = flag.String("db.latency", "fixed", "Query latency distribution for the memory driver: "+strings.Join(latencyDistNames(), ", "))
 		serviceF       = flag.String("dd.service", envWithDefault("DD_SERVICE", "go-prof-app"), "Name of the service.")
 		envF           = flag.String("dd.env", envWithDefault("DD_ENV", "dev"), "Name of the environment the app is running in")
-		powDifficultyF = flag.Int("powDifficulty", 4, "Difficulty level for pow")
+		powDifficultyF = flag.Int("powDifficulty", 4, "Difficulty level for pow, at most 6")
+		mutexFractionF = flag.Int("mutexProfileFraction", profiler.DefaultMutexFraction, "Mutex profile fraction, see runtime.SetMutexProfileFraction. Only applied if -dd.profiles includes mutex or the flag is given. 0 disables mutex profiling.")
+		blockRateF     = flag.Int("blockProfileRate", profiler.DefaultBlockRate, "Block profile rate, see runtime.SetBlockProfileRate. Only applied if -dd.profiles includes block or the flag is given. 0 disables block profiling.")
+		gcPercentF     = flag.String("gcPercent", "", "GC target percentage, see debug.SetGCPercent. -1 or off disables the GC. Empty keeps GOGC.")
+		memoryLimitF   = flag.String("memoryLimit", "", "Soft memory limit, e.g. 512MiB, see debug.SetMemoryLimit. Empty keeps GOMEMLIMIT.")
 		ddKey          = flag.String("dd.key", "", "API key for dd-trace-go agentless profile uploading")
 		ddPeriod       = flag.Duration("dd.period", profiler.DefaultPeriod, "Profiling period for dd-trace-go")
 		ddCPUDuration  = flag.Duration("dd.cpuDuration", profiler.DefaultDuration, "CPU duration for dd-trace-go")
 		ddProfiler     = flag.Bool("dd.profiler", true, "Enable dd-trace-go profiler")
 		ddTracer       = flag.Bool("dd.tracer", true, "Enable dd-trace-go tracer")
+		profileDirF    = flag.String("profile.dir", "", "Write the -dd.profiles to this directory every -dd.period instead of uploading them")
+		profileKeepF   = flag.Int("profile.keep", 100, "Number of profiles to keep per type in -profile.dir, 0 keeps all")
+		profileMaxAgeF = flag.Duration("profile.maxAge", 0, "Remove profiles older than this from -profile.dir, 0 keeps them forever")
 		traceF         = flag.String("trace", "", "Capture execution trace to file.")
+		traceDirF      = flag.String("trace.dir", "traces", "Directory for traces captured via POST /admin/trace")
+		traceKeepF     = flag.Int("trace.keep", 10, "Number of traces to keep in -trace.dir, 0 keeps all")
+		flightWindowF  = flag.Duration("flightRecorder", 0, "Keep this much of the execution trace in memory for snapshots to -trace.dir, 0 disables. Snapshots are taken on SIGUSR1, POST /admin/flight-recorder or slow requests. While enabled, the recorder holds the execution tracer, so /admin/trace and /debug/pprof/trace can't capture traces.")
+		flightSlowF    = flag.Duration("flightRecorder.slow", 0, "Take a flight recorder snapshot when a request takes longer than this, 0 disables")
+		metricsSinkF   = flag.String("metrics.sink", "statsd", "Where to report runtime metrics to: "+strings.Join(metricsSinkNames, ", "))
+		metricsFileF   = flag.String("metrics.file", "metrics.jsonl", "File to write JSON lines to for -metrics.sink=file")
+		metricsModeF   = flag.String("metrics.mode", "distribution", "How to report runtime/metrics histograms: distribution sends every bucket, summary sends avg, min, max and -metrics.quantiles gauges")
+		quantilesF     = flag.String("metrics.quantiles", defaultQuantiles, "Comma separated quantiles to report for -metrics.mode=summary")
+		includeF       = flag.String("metrics.include", "", "Comma separated glob patterns of runtime/metrics to report, e.g. /gc/*,/sched/*. Empty reports all.")
+		excludeF       = flag.String("metrics.exclude", "", "Comma separated glob patterns of runtime/metrics not to report, e.g. /godebug/*")
+		namingF        = flag.String("metrics.naming", "current", "Naming scheme for runtime metrics: "+strings.Join(metricNamerNames(), ", "))
+		intervalF      = flag.Duration("metrics.interval", 10*time.Second, "Interval for reporting runtime metrics and memstats")
+		pprofAddrF     = flag.String("pprof.addr", "localhost:6060", "Listen addr for the net/http/pprof endpoints, empty to disable")
+		shutdownF      = flag.Duration("shutdownTimeout", 10*time.Second, "Max time to wait for in-flight requests on shutdown")
+		scenarioF      = flag.String("scenario", "", "Scenario file defining the endpoints to serve, defaults to scenarios/default.json")
 		versionF       = flag.Bool("version", false, "Print version and exit")
 	)
 	flag.Func("dd.profiles", `Comma separated list of dd-trace-go profiles to enable (default "cpu,heap")`, func(val string) error {
@@ -91,12 +129,33 @@ func run() error {
 		return nil
 	}
 
+	quantiles, err := parseQuantiles(*quantilesF)
+	if err != nil {
+		return fmt.Errorf("bad -metrics.quantiles: %w", err)
+	}
+	if *metricsModeF != "distribution" && *metricsModeF != "summary" {
+		return fmt.Errorf("unknown -metrics.mode: %q", *metricsModeF)
+	} else if *intervalF <= 0 {
+		return fmt.Errorf("-metrics.interval must be positive: %s", *intervalF)
+	}
+	namer, ok := metricNamers[*namingF]
+	if !ok {
+		return fmt.Errorf("unknown -metrics.naming: %q", *namingF)
+	}
+	if *flightWindowF != 0 && *flightWindowF < time.Second {
+		return fmt.Errorf("-flightRecorder must be at least 1s: %s", *flightWindowF)
+	}
+
 	log.Printf("Starting up %s version %s at http %s", *serviceF, version, *addrF)
 
 	if asm := os.Getenv("DD_APPSEC_ENABLED"); asm != "" {
 		mallocTrimEvery(time.Minute)
 	}
 
+	// The deferred calls below run in reverse order once the server has been
+	// drained: tracer.Stop flushes the spans of the drained requests,
+	// profiler.Stop uploads the final profile and trace.Stop finalizes the
+	// execution trace last, so it covers the entire shutdown.
 	if *traceF != "" {
 		log.Printf("Capturing executiong trace to %q", *traceF)
 		traceFile, err := os.Create(*traceF)
@@ -116,16 +175,106 @@ func run() error {
 		profilesS = append(profilesS, p.String())
 	}
 
+	// Set the rates ourselves rather than leaving it to the profiler, so the
+	// mutex and block profiles also have data when the profiler is disabled.
+	// Their overhead is only paid if the profiles are enabled or the rates
+	// were asked for explicitly, e.g. for /debug/pprof.
+	setRates := map[string]bool{}
+	flag.Visit(func(f *flag.Flag) { setRates[f.Name] = true })
+	for _, p := range profiles {
+		switch p {
+		case profiler.MutexProfile:
+			setRates["mutexProfileFraction"] = true
+		case profiler.BlockProfile:
+			setRates["blockProfileRate"] = true
+		}
+	}
+	if setRates["mutexProfileFraction"] {
+		runtime.SetMutexProfileFraction(*mutexFractionF)
+	}
+	if setRates["blockProfileRate"] {
+		runtime.SetBlockProfileRate(*blockRateF)
+	}
+
+	if *gcPercentF != "" {
+		percent := -1
+		if *gcPercentF != "off" {
+			if percent, err = strconv.Atoi(*gcPercentF); err != nil {
+				return fmt.Errorf("bad -gcPercent: %w", err)
+			}
+		}
+		log.Printf("Setting GC percent to %d", percent)
+		debug.SetGCPercent(percent)
+	}
+	if *memoryLimitF != "" {
+		limit, err := parseByteSize(*memoryLimitF)
+		if err != nil {
+			return fmt.Errorf("bad -memoryLimit: %w", err)
+		} else if err := setMemoryLimit(limit); err != nil {
+			return err
+		}
+		log.Printf("Setting memory limit to %d bytes", limit)
+	}
+
+	// Background goroutines are stopped after the server has been drained.
+	bgCtx, stopBackground := context.WithCancel(context.Background())
+	defer stopBackground()
+	var bgWG sync.WaitGroup
+	goBackground := func(fn func(context.Context)) {
+		bgWG.Add(1)
+		go func() {
+			defer bgWG.Done()
+			fn(bgCtx)
+		}()
+	}
+
 	// addr comes from DD_AGENT_HOST
 	statsd, err := statsd.New("")
 	if err != nil {
 		log.Printf("Failed to init statsd client: %s", err)
 	} else {
-		go reportMemstats(statsd)
-		go reportRuntimeMetrics(statsd)
+		defer statsd.Close()
+	}
+
+	metricTags := []string{
+		"service:" + *serviceF,
+		"env:" + *envF,
+		"version:" + version,
+		"go_version:" + runtime.Version(),
+	}
+	sink, err := newMetricsSink(*metricsSinkF, *metricsFileF, statsd, metricTags)
+	if err != nil {
+		if *metricsSinkF != "statsd" {
+			return err
+		}
+		log.Printf("Not reporting runtime metrics: %s. Use -metrics.sink to report them elsewhere.", err)
+	} else {
+		descs := filterMetrics(splitList(*includeF), splitList(*excludeF))
+		log.Printf("Reporting %d runtime metrics to %s every %s", len(descs), *metricsSinkF, *intervalF)
+		defer sink.Close()
+		goBackground(func(ctx context.Context) { reportMemstats(ctx, sink, *intervalF) })
+		if *metricsModeF == "summary" {
+			goBackground(func(ctx context.Context) { reportMetrics(ctx, sink, descs, namer, *intervalF, quantiles) })
+		} else {
+			goBackground(func(ctx context.Context) { reportRuntimeMetrics(ctx, sink, descs, namer, *intervalF) })
+		}
 	}
 
-	if !*ddProfiler {
+	if *profileDirF != "" {
+		if *ddCPUDuration > *ddPeriod {
+			return fmt.Errorf("-dd.cpuDuration must not be longer than -dd.period")
+		}
+		log.Printf("Writing %v profiles to %q every %s", profilesS, *profileDirF, *ddPeriod)
+		lp := &localProfiler{
+			Dir:         *profileDirF,
+			Types:       profiles,
+			Period:      *ddPeriod,
+			CPUDuration: *ddCPUDuration,
+			Keep:        *profileKeepF,
+			MaxAge:      *profileMaxAgeF,
+		}
+		goBackground(lp.Run)
+	} else if !*ddProfiler {
 		log.Printf("Not starting profiler because its disabled")
 	} else {
 		log.Printf("Starting profiler with: %v", profilesS)
@@ -140,6 +289,15 @@ func run() error {
 			profiler.WithStatsd(statsd),
 			profiler.WithTags("go_version:" + runtime.Version()),
 		}
+		for _, p := range profiles {
+			// Keep the profiler from overwriting the rates set above.
+			switch p {
+			case profiler.MutexProfile:
+				profilerOptions = append(profilerOptions, profiler.MutexProfileFraction(*mutexFractionF))
+			case profiler.BlockProfile:
+				profilerOptions = append(profilerOptions, profiler.BlockProfileRate(*blockRateF))
+			}
+		}
 		if *ddKey != "" {
 			log.Printf("Using agentless uploading")
 			profilerOptions = append(
@@ -168,68 +326,178 @@ func run() error {
 		defer tracer.Stop()
 	}
 
-	sqltrace.Register("pgx", stdlib.GetDefaultDriver())
-	db, err := sqltrace.Open("pgx", "postgres://")
+	db, err := openStore(*dbDriverF, *dbDSNF, *dbLatencyF, *maxConnsF)
 	if err != nil {
 		return err
-	} else if err := applySchema(db); err != nil {
-		// Warn about this, we'll keep retrying below anyway.
-		log.Printf("Failed to apply schema: %s", err)
-	} else {
-		log.Printf("Applied schema")
 	}
-	// Hack: The database we're talking to can sometimes be recreated ... restore
-	// the schema if this happens.
-	go restoreSchemaIfLost(db)
+	if sqlDB, ok := db.(*Database); ok {
+		defer sqlDB.Close()
+		// Hack: The database we're talking to can sometimes be recreated ...
+		// restore the schema if this happens.
+		goBackground(func(ctx context.Context) { restoreSchemaIfLost(ctx, sqlDB) })
+	}
 
-	db.SetMaxOpenConns(*maxConnsF)
+	var recorder *flightRecorder
+	if *flightWindowF > 0 {
+		recorder = newFlightRecorder(*flightWindowF, *traceDirF)
+		if err := recorder.Start(); err != nil {
+			return fmt.Errorf("start flight recorder: %w", err)
+		}
+		log.Printf("Flight recorder keeps the last %s of execution trace", *flightWindowF)
+		goBackground(recorder.Run)
+		if len(flightRecorderSignals) > 0 {
+			snapshotCh := make(chan os.Signal, 1)
+			signal.Notify(snapshotCh, flightRecorderSignals...)
+			goBackground(func(ctx context.Context) {
+				defer signal.Stop(snapshotCh)
+				for {
+					select {
+					case sig := <-snapshotCh:
+						if path, err := recorder.Snapshot("received " + sig.String()); err != nil {
+							log.Printf("Failed to save flight recorder snapshot: %s", err)
+						} else {
+							log.Printf("Saved flight recorder snapshot to %q", path)
+						}
+					case <-ctx.Done():
+						return
+					}
+				}
+			})
+		}
+	}
 
 	router := httptrace.New()
-	router.Handler("GET", "/", VersionHandler{Version: version})
-	router.Handler("GET", "/io-bound", &PostsHandler{
-		DB:          db,
-		CPUDuration: 10 * time.Millisecond,
-		SQLDuration: 90 * time.Millisecond,
-	})
-	router.Handler("GET", "/cpu-bound", &PostsHandler{
-		DB:          db,
-		CPUDuration: 90 * time.Millisecond,
-		SQLDuration: 10 * time.Millisecond,
-	})
-	router.Handler("GET", "/cgo-cpu-bound", &PostsHandler{
-		DB:          db,
-		CPUDuration: 90 * time.Millisecond,
-		SQLDuration: 10 * time.Millisecond,
-		CGO:         true,
-	})
-	// Accept GET/POST for transaction endpoint so one can hit it more easily
-	router.Handler("GET", "/transaction", TransactionHandler{DB: db, PowDifficultiy: *powDifficultyF})
-	router.Handler("POST", "/transaction", TransactionHandler{DB: db, PowDifficultiy: *powDifficultyF})
+	handle := func(method, path, handlerType string, h http.Handler) {
+		router.Handler(method, path, TaskHandler(path, LabelHandler(path, handlerType, h)))
+	}
+	handle("GET", "/", "version", VersionHandler{Version: version})
+	handle("GET", "/metrics", "metrics", MetricsHandler{})
+	scenario, err := LoadScenario(*scenarioF)
+	if err != nil {
+		return err
+	}
+	routes, err := scenario.Routes(db, *powDifficultyF)
+	if err != nil {
+		return err
+	}
+	for _, r := range routes {
+		if recorder == nil || *flightSlowF == 0 {
+			handle(r.Method, r.Path, r.Name, r.Handler)
+			continue
+		}
+		// Wrap outside of LabelHandler, it needs to see the route's handler.
+		router.Handler(r.Method, r.Path, SlowRequestHandler{
+			Recorder:  recorder,
+			Threshold: *flightSlowF,
+			Route:     r.Path,
+			Handler:   TaskHandler(r.Path, LabelHandler(r.Path, r.Name, r.Handler)),
+		})
+	}
+	handle("GET", "/admin/goroutine-leaks", "goroutine-leak-admin", GoroutineLeakAdminHandler{DB: db})
+	handle("POST", "/admin/goroutine-leaks", "goroutine-leak-admin", GoroutineLeakAdminHandler{DB: db})
+	handlerAdmin := NewHandlerAdminHandler(db, routes)
+	handle("GET", "/admin/handlers", "handler-admin", handlerAdmin)
+	handle("POST", "/admin/handlers", "handler-admin", handlerAdmin)
+	traces := &traceCapture{}
+	traceAdmin := TraceAdminHandler{DB: db, Capture: traces, FlightRecorder: recorder, Dir: *traceDirF, Keep: *traceKeepF}
+	handle("GET", "/admin/trace", "trace-admin", traceAdmin)
+	handle("POST", "/admin/trace", "trace-admin", traceAdmin)
+	handle("DELETE", "/admin/trace", "trace-admin", traceAdmin)
+	if recorder != nil {
+		flightAdmin := FlightRecorderAdminHandler{DB: db, Recorder: recorder}
+		handle("GET", "/admin/flight-recorder", "flight-recorder-admin", flightAdmin)
+		handle("POST", "/admin/flight-recorder", "flight-recorder-admin", flightAdmin)
+	}
 
 	sigCh := make(chan os.Signal, 1)
-	signal.Notify(sigCh, os.Interrupt)
+	signal.Notify(sigCh, os.Interrupt, syscall.SIGTERM)
 
 	server := &http.Server{
 		Addr:        *addrF,
 		Handler:     router,
 		IdleTimeout: 60 * time.Second,
 	}
-	go server.ListenAndServe()
+	servers := []*http.Server{server}
+	if *pprofAddrF != "" {
+		log.Printf("Serving pprof endpoints at http %s/debug/pprof/", *pprofAddrF)
+		servers = append(servers, &http.Server{
+			Addr:    *pprofAddrF,
+			Handler: newPprofMux(),
+		})
+	}
+	serverErrCh := make(chan error, 1)
+	go func() { serverErrCh <- server.ListenAndServe() }()
+	for _, s := range servers[1:] {
+		// The pprof endpoints are optional, so a taken port, e.g. by another
+		// instance on the same host, doesn't take the app down.
+		go func(s *http.Server) {
+			if err := s.ListenAndServe(); err != http.ErrServerClosed {
+				log.Printf("Failed to serve pprof endpoints: %s", err)
+			}
+		}(s)
+	}
+
+	select {
+	case err := <-serverErrCh:
+		return err
+	case sig := <-sigCh:
+		// Restore the default behavior, so a second signal kills the process
+		// if the shutdown is taking too long.
+		signal.Stop(sigCh)
+		log.Printf("Received %s, shutting down", sig)
+	}
 
-	sig := <-sigCh
-	log.Printf("Received %s, shutting down", sig)
+	// Finish traces captured via /admin/trace, streaming ones would otherwise
+	// hold up the shutdown until they're done.
+	traces.Shutdown()
+
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), *shutdownF)
+	defer cancel()
+	for _, s := range servers {
+		if err := s.Shutdown(shutdownCtx); err != nil {
+			log.Printf("Failed to drain in-flight requests for %s: %s", s.Addr, err)
+		} else {
+			log.Printf("Drained in-flight requests for %s", s.Addr)
+		}
+	}
+
+	stopBackground()
+	bgWG.Wait()
 	return nil
 }
 
-func applySchema(db *sql.DB) error {
-	_, err := db.Exec(schemaSQL)
+// openStore returns the Store for the given driver. The memory driver serves
+// all data from memory, all other drivers are backed by a SQL database.
+func openStore(driver, dsn, latencyDist string, maxConns int) (Store, error) {
+	if driver == "memory" {
+		log.Printf("Using in-memory store with %s latency", latencyDist)
+		return NewMemoryStore(latencyDist)
+	}
+
+	db, err := OpenDatabase(driver, dsn)
+	if err != nil {
+		return nil, err
+	} else if err := applySchema(db); err != nil {
+		// Warn about this, we'll keep retrying below anyway.
+		log.Printf("Failed to apply schema: %s", err)
+	} else {
+		log.Printf("Applied %s schema", driver)
+	}
+	db.SetMaxOpenConns(maxConns)
+	return db, nil
+}
+
+func applySchema(db *Database) error {
+	_, err := db.Exec(db.Dialect.Schema)
 	if err != nil {
 		return fmt.Errorf("applySchema: %w", err)
 	}
 	return nil
 }
 
-func restoreSchemaIfLost(db *sql.DB) {
+func restoreSchemaIfLost(ctx context.Context, db *Database) {
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
 	for {
 		var err error
 		var exists bool
@@ -242,21 +510,18 @@ func restoreSchemaIfLost(db *sql.DB) {
 		if err != nil {
 			log.Printf("Lost schema and failed to restore it: %s", err)
 		}
-		time.Sleep(time.Second)
+
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			return
+		}
 	}
 }
 
-func checkUsersTableExists(db *sql.DB) (bool, error) {
-	const query = `
-	SELECT EXISTS (
-		SELECT FROM information_schema.tables
-		WHERE table_schema = 'public'
-		AND table_name = 'users'
-	);
-	`
-
+func checkUsersTableExists(db *Database) (bool, error) {
 	var exists bool
-	err := db.QueryRow(query).Scan(&exists)
+	err := db.QueryRow(db.Dialect.UsersTableExistsQuery).Scan(&exists)
 	if err != nil {
 		return false, fmt.Errorf("checkUsersTableExists: %w", err)
 	}
@@ -264,75 +529,98 @@ func checkUsersTableExists(db *sql.DB) (bool, error) {
 	return exists, nil
 }
 
-func reportMemstats(statsd *statsd.Client) {
+// memstatsGauges are the runtime.MemStats fields reported as gauges.
+var memstatsGauges = []struct {
+	Name  string
+	Value func(*runtime.MemStats) float64
+}{
+	{"alloc", func(s *runtime.MemStats) float64 { return float64(s.Alloc) }},
+	{"buckhashsys", func(s *runtime.MemStats) float64 { return float64(s.BuckHashSys) }},
+	{"frees", func(s *runtime.MemStats) float64 { return float64(s.Frees) }},
+	{"gccpufraction", func(s *runtime.MemStats) float64 { return float64(s.GCCPUFraction) }},
+	{"gcsys", func(s *runtime.MemStats) float64 { return float64(s.GCSys) }},
+	{"heapalloc", func(s *runtime.MemStats) float64 { return float64(s.HeapAlloc) }},
+	{"heapidle", func(s *runtime.MemStats) float64 { return float64(s.HeapIdle) }},
+	{"heapinuse", func(s *runtime.MemStats) float64 { return float64(s.HeapInuse) }},
+	{"heapobjects", func(s *runtime.MemStats) float64 { return float64(s.HeapObjects) }},
+	{"heapreleased", func(s *runtime.MemStats) float64 { return float64(s.HeapReleased) }},
+	{"heapsys", func(s *runtime.MemStats) float64 { return float64(s.HeapSys) }},
+	{"lastgc", func(s *runtime.MemStats) float64 { return float64(s.LastGC) }},
+	{"lookups", func(s *runtime.MemStats) float64 { return float64(s.Lookups) }},
+	{"mcacheinuse", func(s *runtime.MemStats) float64 { return float64(s.MCacheInuse) }},
+	{"mcachesys", func(s *runtime.MemStats) float64 { return float64(s.MCacheSys) }},
+	{"mspaninuse", func(s *runtime.MemStats) float64 { return float64(s.MSpanInuse) }},
+	{"mspansys", func(s *runtime.MemStats) float64 { return float64(s.MSpanSys) }},
+	{"mallocs", func(s *runtime.MemStats) float64 { return float64(s.Mallocs) }},
+	{"numforcedgc", func(s *runtime.MemStats) float64 { return float64(s.NumForcedGC) }},
+	{"numgc", func(s *runtime.MemStats) float64 { return float64(s.NumGC) }},
+	{"othersys", func(s *runtime.MemStats) float64 { return float64(s.OtherSys) }},
+	{"pausetotalns", func(s *runtime.MemStats) float64 { return float64(s.PauseTotalNs) }},
+	{"stackinuse", func(s *runtime.MemStats) float64 { return float64(s.StackInuse) }},
+	{"stacksys", func(s *runtime.MemStats) float64 { return float64(s.StackSys) }},
+	{"sys", func(s *runtime.MemStats) float64 { return float64(s.Sys) }},
+	{"totalalloc", func(s *runtime.MemStats) float64 { return float64(s.TotalAlloc) }},
+}
+
+func reportMemstats(ctx context.Context, sink MetricsSink, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
 	for {
 		var stats runtime.MemStats
 		runtime.ReadMemStats(&stats)
-		statsd.Gauge("go.memstats.alloc", float64(stats.Alloc), nil, 1)
-		statsd.Gauge("go.memstats.buckhashsys", float64(stats.BuckHashSys), nil, 1)
-		statsd.Gauge("go.memstats.frees", float64(stats.Frees), nil, 1)
-		statsd.Gauge("go.memstats.gccpufraction", float64(stats.GCCPUFraction), nil, 1)
-		statsd.Gauge("go.memstats.gcsys", float64(stats.GCSys), nil, 1)
-		statsd.Gauge("go.memstats.heapalloc", float64(stats.HeapAlloc), nil, 1)
-		statsd.Gauge("go.memstats.heapidle", float64(stats.HeapIdle), nil, 1)
-		statsd.Gauge("go.memstats.heapinuse", float64(stats.HeapInuse), nil, 1)
-		statsd.Gauge("go.memstats.heapobjects", float64(stats.HeapObjects), nil, 1)
-		statsd.Gauge("go.memstats.heapreleased", float64(stats.HeapReleased), nil, 1)
-		statsd.Gauge("go.memstats.heapsys", float64(stats.HeapSys), nil, 1)
-		statsd.Gauge("go.memstats.lastgc", float64(stats.LastGC), nil, 1)
-		statsd.Gauge("go.memstats.lookups", float64(stats.Lookups), nil, 1)
-		statsd.Gauge("go.memstats.mcacheinuse", float64(stats.MCacheInuse), nil, 1)
-		statsd.Gauge("go.memstats.mcachesys", float64(stats.MCacheSys), nil, 1)
-		statsd.Gauge("go.memstats.mspaninuse", float64(stats.MSpanInuse), nil, 1)
-		statsd.Gauge("go.memstats.mspansys", float64(stats.MSpanSys), nil, 1)
-		statsd.Gauge("go.memstats.mallocs", float64(stats.Mallocs), nil, 1)
-		statsd.Gauge("go.memstats.numforcedgc", float64(stats.NumForcedGC), nil, 1)
-		statsd.Gauge("go.memstats.numgc", float64(stats.NumGC), nil, 1)
-		statsd.Gauge("go.memstats.othersys", float64(stats.OtherSys), nil, 1)
-		statsd.Gauge("go.memstats.pausetotalns", float64(stats.PauseTotalNs), nil, 1)
-		statsd.Gauge("go.memstats.stackinuse", float64(stats.StackInuse), nil, 1)
-		statsd.Gauge("go.memstats.stacksys", float64(stats.StackSys), nil, 1)
-		statsd.Gauge("go.memstats.sys", float64(stats.Sys), nil, 1)
-		statsd.Gauge("go.memstats.totalalloc", float64(stats.TotalAlloc), nil, 1)
-		time.Sleep(10 * time.Second)
+		for _, g := range memstatsGauges {
+			sink.Gauge("go.memstats."+g.Name, g.Value(&stats))
+		}
+		if err := sink.Flush(); err != nil {
+			log.Printf("Failed to flush memstats: %s", err)
+		}
+
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			return
+		}
 	}
 }
 
-func reportMetrics(statsd statsd.ClientInterface) {
-	descs := metrics.All()
+// reportMetrics is the summary mode counterpart of reportRuntimeMetrics. It
+// reports the same metrics, but histograms are reduced to gauges for their
+// average, min, max and the given quantiles instead of being sent as
+// distributions.
+func reportMetrics(ctx context.Context, sink MetricsSink, descs []metrics.Description, namer metricNamer, interval time.Duration, quantiles []float64) {
+	descs, names := namedMetrics(descs, namer)
 	samples := make([]metrics.Sample, len(descs))
 	for i := range samples {
 		samples[i].Name = descs[i].Name
 	}
 
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
 	for {
 		metrics.Read(samples)
-		for _, sample := range samples {
-			name, value := sample.Name, sample.Value
-			name, unit := metricName(name)
+		for i, sample := range samples {
+			name, value := names[i], sample.Value
+			// Can't fail, the metric was named by namedMetrics.
+			statName := func(stat string) string {
+				name, _ := namer(descs[i], stat)
+				return name
+			}
 
 			switch value.Kind() {
 			case metrics.KindUint64:
-				statsd.Gauge(name+"."+unit, float64(value.Uint64()), nil, 1)
+				sink.Gauge(name, float64(value.Uint64()))
 			case metrics.KindFloat64:
-				statsd.Gauge(name+"."+unit, value.Float64(), nil, 1)
+				sink.Gauge(name, value.Float64())
 			case metrics.KindFloat64Histogram:
-				//if name == "runtime.go.metrics.sched.latencies" {
-				//for _, v := range value.Float64Histogram().Buckets {
-				//if math.IsInf(v, 0) {
-				//continue
-				//}
-				//fmt.Printf("%s ", time.Duration(v*float64(time.Second)))
-				//}
-				//fmt.Printf("\n---------------------\n")
-				//}
-				stats := newHistStats(value.Float64Histogram())
-				statsd.Gauge(name+".avg."+unit, stats.Avg, nil, 1)
-				statsd.Gauge(name+".min."+unit, stats.Min, nil, 1)
-				statsd.Gauge(name+".max."+unit, stats.Max, nil, 1)
-				statsd.Gauge(name+".median."+unit, stats.Median, nil, 1)
-				statsd.Gauge(name+".p95."+unit, stats.P95, nil, 1)
-				statsd.Gauge(name+".p99."+unit, stats.P99, nil, 1)
+				stats := newHistStats(value.Float64Histogram(), quantiles)
+				sink.Gauge(statName("avg"), stats.Avg)
+				sink.Gauge(statName("min"), stats.Min)
+				sink.Gauge(statName("max"), stats.Max)
+				for i, q := range quantiles {
+					sink.Gauge(statName(quantileName(q)), stats.Quantiles[i])
+				}
+				sink.Gauge(statName("underflow"), float64(stats.Underflow))
+				sink.Gauge(statName("overflow"), float64(stats.Overflow))
 			case metrics.KindBad:
 				// This should never happen because all metrics are supported
 				// by construction.
@@ -346,87 +634,136 @@ func reportMetrics(statsd statsd.ClientInterface) {
 				fmt.Printf("%s: unexpected metric Kind: %v\n", name, value.Kind())
 			}
 		}
-		time.Sleep(10 * time.Second)
-	}
-}
-
-func newHistStats(h *metrics.Float64Histogram) (s histStats) {
-	// TODO(fg) very inefficent implementation
-	s.Avg = histAvg(h)
-	s.Min = histPercentile(h, 0)
-	s.Median = histPercentile(h, 0.5)
-	s.P95 = histPercentile(h, 0.95)
-	s.P99 = histPercentile(h, 0.99)
-	s.Max = histPercentile(h, 1)
-	return
-}
 
-type histStats struct {
-	Avg    float64
-	Min    float64 // aka P0
-	Median float64 // aka P50
-	P95    float64
-	P99    float64
-	Max    float64 // aka P100
-}
+		if err := sink.Flush(); err != nil {
+			log.Printf("Failed to flush runtime metrics: %s", err)
+		}
 
-// see https://www.statology.org/histogram-mean-median/
-func histAvg(h *metrics.Float64Histogram) float64 {
-	var sum float64
-	var count float64
-	for i, val := range h.Counts {
-		min, max := h.Buckets[i], h.Buckets[i+1]
-		// TODO(fg) is there a better way?
-		if math.IsInf(min, 0) || math.IsInf(max, 0) {
-			continue
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			return
 		}
-		sum += float64(val) * (min + max) / 2
-		count += float64(val)
 	}
-	return sum / count
 }
 
-// see https://stats.stackexchange.com/a/65718
-func histPercentile(h *metrics.Float64Histogram, p float64) float64 {
-	var countSum uint64
+// newHistStats computes the stats of h, including the given quantiles which
+// must be sorted in ascending order, see parseQuantiles. Values are linearly
+// interpolated within buckets, open-ended buckets are clamped to their finite
+// bound, see histBucketValue. All stats are 0 for empty histograms.
+//
+// The buckets are scanned once, recording the cumulative counts. Each
+// quantile is then resolved by a binary search over those counts rather than
+// by scanning the buckets again.
+func newHistStats(h *metrics.Float64Histogram, quantiles []float64) (s histStats) {
+	s.Quantiles = make([]float64, len(quantiles))
+
+	var total uint64
+	var sum float64
+	first, last := -1, -1
+	cum := make([]uint64, len(h.Counts))
 	for i, count := range h.Counts {
-		min, max := h.Buckets[i], h.Buckets[i+1]
-		// TODO(fg) is there a better way?
-		if math.IsInf(min, 0) || math.IsInf(max, 0) {
+		total += count
+		cum[i] = total
+		if count == 0 {
 			continue
 		}
-		countSum += count
+		if math.IsInf(h.Buckets[i], -1) {
+			s.Underflow += count
+		}
+		if math.IsInf(h.Buckets[i+1], 1) {
+			s.Overflow += count
+		}
+		sum += float64(count) * histBucketValue(h.Buckets[i], h.Buckets[i+1])
+		if first < 0 {
+			first = i
+		}
+		last = i
 	}
-	var countCum uint64
-	var min, max float64
-	for i, count := range h.Counts {
-		min, max = h.Buckets[i], h.Buckets[i+1]
-		// TODO(fg) is there a better way?
-		if math.IsInf(min, 0) || math.IsInf(max, 0) {
+	if total == 0 {
+		return
+	}
+	s.Avg = sum / float64(total)
+	s.Min, _ = histBucketBounds(h.Buckets[first], h.Buckets[first+1])
+	_, s.Max = histBucketBounds(h.Buckets[last], h.Buckets[last+1])
+
+	for j, q := range quantiles {
+		rank := q * float64(total)
+		// The first non-empty bucket whose cumulative count reaches rank.
+		i := sort.Search(len(cum), func(i int) bool { return cum[i] > 0 && float64(cum[i]) >= rank })
+		if i == len(cum) {
+			// Rounding errors can leave the largest quantiles unresolved.
+			s.Quantiles[j] = s.Max
 			continue
 		}
-
-		if p == 0 {
-			return min
-		}
-
-		countCum += count
-		if float64(countCum) >= float64(countSum)*p {
-			break
+		count := h.Counts[i]
+		frac := (rank - float64(cum[i]-count)) / float64(count)
+		if frac < 0 {
+			frac = 0
 		}
+		lo, hi := histBucketBounds(h.Buckets[i], h.Buckets[i+1])
+		s.Quantiles[j] = lo + (hi-lo)*frac
 	}
-	if p == 1 {
-		return max
+	return
+}
+
+type histStats struct {
+	Avg float64
+	Min float64
+	Max float64
+	// Quantiles holds the values for the quantiles passed to newHistStats in
+	// the same order.
+	Quantiles []float64
+	// Underflow and Overflow count the samples below and above the finite
+	// range of the histogram. They're included in the stats above by clamping
+	// them to the closest finite bound, see histBucketValue.
+	Underflow uint64
+	Overflow  uint64
+}
+
+// histBucketBounds returns the bounds used for interpolating within the bucket
+// [min, max). Infinite bounds are replaced by the finite one, so all samples
+// of an open-ended bucket have the same value as in histBucketValue.
+func histBucketBounds(min, max float64) (lo, hi float64) {
+	if math.IsInf(min, -1) || math.IsInf(max, 1) {
+		v := histBucketValue(min, max)
+		return v, v
 	}
-	return (min + max) / 2
+	return min, max
 }
 
-var metricRegex = regexp.MustCompile("^(?P<name>/[^:]+):(?P<unit>[^:*/]+(?:[*/][^:*/]+)*)$")
+// defaultQuantiles is the default value of the -metrics.quantiles flag.
+const defaultQuantiles = "0.5,0.95,0.99"
 
-func metricName(runtimeName string) (string, string) {
-	m := metricRegex.FindStringSubmatch(runtimeName)
-	if len(m) != 3 {
-		return "", ""
+// parseQuantiles parses a comma separated list of quantiles between 0 and 1
+// and returns them sorted and without duplicates.
+func parseQuantiles(val string) ([]float64, error) {
+	var quantiles []float64
+	for _, field := range strings.Split(val, ",") {
+		field = strings.TrimSpace(field)
+		if field == "" {
+			continue
+		}
+		q, err := strconv.ParseFloat(field, 64)
+		if err != nil {
+			return nil, fmt.Errorf("bad quantile: %w", err)
+		} else if !(q >= 0 && q <= 1) {
+			return nil, fmt.Errorf("quantile must be between 0 and 1: %s", field)
+		}
+		quantiles = append(quantiles, q)
 	}
-	return "runtime.go.metrics." + strings.ReplaceAll(m[1][1:], "/", "."), m[2]
+	sort.Float64s(quantiles)
+	deduped := quantiles[:0]
+	for i, q := range quantiles {
+		if i == 0 || q != quantiles[i-1] {
+			deduped = append(deduped, q)
+		}
+	}
+	return deduped, nil
+}
+
+// quantileName returns the metric name used for q, e.g. p99 for 0.99 and
+// p99.9 for 0.999.
+func quantileName(q float64) string {
+	return "p" + strconv.FormatFloat(math.Round(q*1e6)/1e4, 'f', -1, 64)
 }
@@ -1,16 +1,16 @@
 package main
 
 import (
+	"context"
 	"math"
+	"reflect"
 	"runtime/metrics"
 	"testing"
 	"time"
-
-	"github.com/DataDog/datadog-go/statsd"
 )
 
 func Test_metricName(t *testing.T) {
-	go reportMetrics(&statsd.NoOpClient{})
+	go reportMetrics(context.Background(), nopSink{}, filterMetrics(nil, nil), currentMetricName, 10*time.Second, []float64{0.5, 0.99})
 	time.Sleep(10 * time.Second)
 	//for _, d := range metrics.All() {
 	//fmt.Printf("%s -> %s\n", d.Name, metricName(d.Name))
@@ -18,6 +18,7 @@ func Test_metricName(t *testing.T) {
 }
 
 func Test_newHistStats(t *testing.T) {
+	quantiles := []float64{0.5, 0.95, 0.99}
 	tests := []struct {
 		In   *metrics.Float64Histogram
 		Want histStats
@@ -28,12 +29,10 @@ func Test_newHistStats(t *testing.T) {
 				Buckets: []float64{1, 11, 21, 31, 41, 51},
 			},
 			Want: histStats{
-				Avg:    23.39,
-				Min:    1,
-				Median: (21 + 31) / 2,
-				P95:    (31 + 41) / 2,
-				P99:    (41 + 51) / 2,
-				Max:    51,
+				Avg:       23.39,
+				Min:       1,
+				Max:       51,
+				Quantiles: []float64{23.5, 40.5, 48.7},
 			},
 		},
 		{
@@ -41,18 +40,39 @@ func Test_newHistStats(t *testing.T) {
 				Counts:  []uint64{100, 2, 7, 10, 3, 1, 100},
 				Buckets: []float64{math.Inf(-1), 1, 11, 21, 31, 41, 51, math.Inf(1)},
 			},
+			// The open-ended buckets are clamped to 1 and 51.
 			Want: histStats{
-				Avg:    23.39,
-				Min:    1,
-				Median: (21 + 31) / 2,
-				P95:    (31 + 41) / 2,
-				P99:    (41 + 51) / 2,
-				Max:    51,
+				Avg:       (100*1 + 538 + 100*51) / 223.0,
+				Min:       1,
+				Max:       51,
+				Quantiles: []float64{23.5, 51, 51},
+				Underflow: 100,
+				Overflow:  100,
+			},
+		},
+		{
+			In: &metrics.Float64Histogram{
+				Counts:  []uint64{0, 0, 0, 5},
+				Buckets: []float64{math.Inf(-1), 1, 11, 21, math.Inf(1)},
+			},
+			Want: histStats{
+				Avg:       21,
+				Min:       21,
+				Max:       21,
+				Quantiles: []float64{21, 21, 21},
+				Overflow:  5,
+			},
+		},
+		{
+			In: &metrics.Float64Histogram{
+				Counts:  []uint64{0, 0},
+				Buckets: []float64{math.Inf(-1), 1, math.Inf(1)},
 			},
+			Want: histStats{Quantiles: []float64{0, 0, 0}},
 		},
 	}
 	for _, test := range tests {
-		got := newHistStats(test.In)
+		got := newHistStats(test.In, quantiles)
 		if math.Abs(got.Avg-test.Want.Avg) > 0.1 {
 			t.Fatalf("got=%f want=%f", got.Avg, test.Want.Avg)
 		}
@@ -62,14 +82,42 @@ func Test_newHistStats(t *testing.T) {
 		if math.Abs(got.Max-test.Want.Max) > 0.1 {
 			t.Fatalf("got=%f want=%f", got.Max, test.Want.Max)
 		}
-		if math.Abs(got.Median-test.Want.Median) > 0.1 {
-			t.Fatalf("got=%f want=%f", got.Median, test.Want.Median)
+		for i, q := range quantiles {
+			if math.Abs(got.Quantiles[i]-test.Want.Quantiles[i]) > 0.1 {
+				t.Fatalf("p%v: got=%f want=%f", q, got.Quantiles[i], test.Want.Quantiles[i])
+			}
 		}
-		if math.Abs(got.P95-test.Want.P95) > 0.1 {
-			t.Fatalf("got=%f want=%f", got.P95, test.Want.P95)
+		if got.Underflow != test.Want.Underflow || got.Overflow != test.Want.Overflow {
+			t.Fatalf("got=%d/%d want=%d/%d", got.Underflow, got.Overflow, test.Want.Underflow, test.Want.Overflow)
 		}
-		if math.Abs(got.P99-test.Want.P99) > 0.1 {
-			t.Fatalf("got=%f want=%f", got.P99, test.Want.P99)
+	}
+
+	// The extreme quantiles skip empty buckets.
+	h := &metrics.Float64Histogram{Counts: []uint64{0, 4, 0, 4}, Buckets: []float64{0, 10, 20, 30, 40}}
+	if got, want := newHistStats(h, []float64{0, 1}).Quantiles, []float64{10, 40}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("got=%v want=%v", got, want)
+	}
+}
+
+func Test_parseQuantiles(t *testing.T) {
+	got, err := parseQuantiles("0.99, 0.5,0.999,0.5")
+	if err != nil {
+		t.Fatal(err)
+	} else if want := []float64{0.5, 0.99, 0.999}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("got=%v want=%v", got, want)
+	}
+	for _, val := range []string{"1.5", "-0.1", "p99", "NaN"} {
+		if _, err := parseQuantiles(val); err == nil {
+			t.Fatalf("%s: expected error", val)
+		}
+	}
+}
+
+func Test_quantileName(t *testing.T) {
+	tests := map[float64]string{0: "p0", 0.5: "p50", 0.99: "p99", 0.999: "p99.9", 1: "p100"}
+	for q, want := range tests {
+		if got := quantileName(q); got != want {
+			t.Fatalf("got=%s want=%s", got, want)
 		}
 	}
 }
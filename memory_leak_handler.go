@@ -0,0 +1,155 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+	"unsafe"
+)
+
+// maxLeakBytes limits the bytes leaked by a single MemoryLeakHandler request.
+// The leak is grown while holding the lock of leakedPosts, so larger requests
+// would block all other leak requests for a long time.
+const maxLeakBytes = 256 << 20
+
+// leakedPosts holds on to the posts retained by MemoryLeakHandler for the
+// lifetime of the process (or until it's reset).
+var leakedPosts = &postLeak{}
+
+// MemoryLeakHandler simulates a memory leak by fetching posts like
+// PostsHandler and then retaining them in a global structure that keeps
+// growing with every request.
+//
+// The following query parameters are supported:
+//
+//	bytes: Number of bytes to leak per request, at most 256 MiB (default:
+//	       size of the posts)
+//	cap:   Stop growing once the leak reaches this many bytes (default: none)
+//	reset: If set to 1 or true, release all leaked memory instead
+type MemoryLeakHandler struct {
+	DB          Store
+	SQLDuration time.Duration
+}
+
+func (h MemoryLeakHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	userID, ok := auth(h.DB, w, r)
+	if !ok {
+		return
+	}
+
+	q := r.URL.Query()
+	if reset, _ := strconv.ParseBool(q.Get("reset")); reset {
+		freed := leakedPosts.Reset()
+		fmt.Fprintf(w, "released %d bytes\n", freed)
+		return
+	}
+
+	bytes, err := parseIntParam(q.Get("bytes"))
+	if err != nil {
+		respondErr(w, http.StatusBadRequest, "bad bytes: %s\n", err)
+		return
+	} else if bytes > maxLeakBytes {
+		respondErr(w, http.StatusBadRequest, "bad bytes: must not exceed %d: %d\n", maxLeakBytes, bytes)
+		return
+	}
+	limit, err := parseIntParam(q.Get("cap"))
+	if err != nil {
+		respondErr(w, http.StatusBadRequest, "bad cap: %s\n", err)
+		return
+	}
+
+	ph := &PostsHandler{DB: h.DB, SQLDuration: h.SQLDuration}
+	posts, err := ph.ioWork(r.Context(), userID)
+	if err != nil {
+		respondErr(w, http.StatusInternalServerError, "ioWork: %s", err)
+		return
+	}
+
+	leaked, total := leakedPosts.Add(posts, bytes, limit)
+	fmt.Fprintf(w, "leaked %d bytes, total %d bytes\n", leaked, total)
+}
+
+// parseIntParam parses a non-negative integer query parameter. An empty
+// value is treated as 0.
+func parseIntParam(val string) (int64, error) {
+	if val == "" {
+		return 0, nil
+	}
+	n, err := strconv.ParseInt(val, 10, 64)
+	if err != nil {
+		return 0, err
+	} else if n < 0 {
+		return 0, fmt.Errorf("must not be negative: %d", n)
+	}
+	return n, nil
+}
+
+// postLeak is a concurrency safe, ever growing list of posts.
+type postLeak struct {
+	mu    sync.Mutex
+	posts [][]*Post
+	bytes int64
+}
+
+// Add retains copies of posts until at least the given number of bytes have
+// been leaked. If bytes is 0, posts are retained once. If limit is > 0, the
+// leak will not grow beyond it. Add returns the number of bytes leaked by
+// this call and the total size of the leak.
+func (l *postLeak) Add(posts []*Post, bytes, limit int64) (int64, int64) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	size := postsSize(posts)
+	if size == 0 {
+		return 0, l.bytes
+	}
+
+	var leaked int64
+	for leaked == 0 || leaked < bytes {
+		if limit > 0 && l.bytes+size > limit {
+			break
+		}
+		l.posts = append(l.posts, clonePosts(posts))
+		l.bytes += size
+		leaked += size
+	}
+	return leaked, l.bytes
+}
+
+// Reset releases all leaked posts and returns the number of bytes released.
+func (l *postLeak) Reset() int64 {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	freed := l.bytes
+	l.posts = nil
+	l.bytes = 0
+	return freed
+}
+
+// clonePosts returns a deep copy of posts so that every copy contributes its
+// full size to the heap.
+func clonePosts(posts []*Post) []*Post {
+	clone := make([]*Post, len(posts))
+	for i, p := range posts {
+		clone[i] = &Post{
+			ID:     p.ID,
+			UserID: p.UserID,
+			Title:  string([]byte(p.Title)),
+			Body:   string([]byte(p.Body)),
+		}
+	}
+	return clone
+}
+
+// postsSize returns the approximate number of heap bytes used by a deep copy
+// of posts.
+func postsSize(posts []*Post) int64 {
+	size := int64(len(posts)) * int64(unsafe.Sizeof(&Post{}))
+	for _, p := range posts {
+		size += int64(unsafe.Sizeof(*p)) + int64(len(p.Title)+len(p.Body))
+	}
+	return size
+}
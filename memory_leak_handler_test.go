@@ -0,0 +1,54 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"testing"
+)
+
+func Test_postLeak(t *testing.T) {
+	posts := []*Post{
+		{ID: 1, UserID: 1, Title: "foo", Body: "bar"},
+		{ID: 2, UserID: 1, Title: "foobar", Body: "foobar"},
+	}
+	size := postsSize(posts)
+
+	l := &postLeak{}
+	if leaked, total := l.Add(posts, 0, 0); leaked != size || total != size {
+		t.Fatalf("got=%d,%d want=%d,%d", leaked, total, size, size)
+	}
+	if leaked, total := l.Add(posts, 3*size, 0); leaked != 3*size || total != 4*size {
+		t.Fatalf("got=%d,%d want=%d,%d", leaked, total, 3*size, 4*size)
+	}
+	if leaked, total := l.Add(posts, 3*size, 5*size); leaked != size || total != 5*size {
+		t.Fatalf("got=%d,%d want=%d,%d", leaked, total, size, 5*size)
+	}
+	if leaked, total := l.Add(posts, 0, 5*size); leaked != 0 || total != 5*size {
+		t.Fatalf("got=%d,%d want=%d,%d", leaked, total, 0, 5*size)
+	}
+	if got := len(l.posts); got != 5 {
+		t.Fatalf("got=%d want=%d", got, 5)
+	}
+	if freed := l.Reset(); freed != 5*size {
+		t.Fatalf("got=%d want=%d", freed, 5*size)
+	}
+	if got := l.bytes; got != 0 {
+		t.Fatalf("got=%d want=%d", got, 0)
+	}
+}
+
+func Test_MemoryLeakHandler(t *testing.T) {
+	store, err := NewMemoryStore("fixed")
+	if err != nil {
+		t.Fatal(err)
+	}
+	h := MemoryLeakHandler{DB: store}
+	q := url.Values{"key": {seedAPIKey}, "bytes": {strconv.Itoa(maxLeakBytes + 1)}}
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest("GET", "/memory-leak?"+q.Encode(), nil))
+	if rec.Code != http.StatusBadRequest {
+		t.Fatalf("got=%d want=%d", rec.Code, http.StatusBadRequest)
+	}
+}
@@ -0,0 +1,13 @@
+//go:build go1.19
+// +build go1.19
+
+package main
+
+import "runtime/debug"
+
+// setMemoryLimit sets the soft memory limit of the runtime, see
+// debug.SetMemoryLimit.
+func setMemoryLimit(limit int64) error {
+	debug.SetMemoryLimit(limit)
+	return nil
+}
@@ -0,0 +1,11 @@
+//go:build !go1.19
+// +build !go1.19
+
+package main
+
+import "fmt"
+
+// setMemoryLimit fails because debug.SetMemoryLimit was added in Go 1.19.
+func setMemoryLimit(limit int64) error {
+	return fmt.Errorf("setting a memory limit requires go1.19 or later")
+}
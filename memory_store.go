@@ -0,0 +1,148 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"math/rand"
+	"sort"
+	"sync"
+	"time"
+)
+
+// seedAPIKey is the api key of the user created by the schema files.
+const seedAPIKey = "9A0830DE-CB45-42B0-8155-BB61733AB5B0"
+
+const loremIpsum = "ipsum dolor sit amet, consectetur adipiscing elit. Suspendisse non urna vestibulum orci sollicitudin egestas. Sed gravida at lectus non ornare. Sed accumsan tellus nec ligula feugiat vestibulum. Nullam commodo ac odio vel euismod. Proin posuere, ipsum et fringilla viverra, nisl sem venenatis purus, at pretium nisl lorem a augue. Maecenas et justo tellus. Nunc rutrum blandit nulla, tempus dictum est bibendum vitae. Phasellus at mauris quis justo vehicula sagittis et quis ipsum. Nullam dictum tempor enim et viverra. Etiam sagittis rhoncus ex, vel rutrum turpis euismod quis. Integer tristique nulla vulputate neque tempus maximus. Mauris lacinia turpis leo."
+
+// Store provides the data used by the handlers. It's implemented by
+// *Database for real SQL backends and by *MemoryStore.
+type Store interface {
+	// UserID returns the id of the user with the given api key, or
+	// sql.ErrNoRows if there is no such user.
+	UserID(ctx context.Context, apiKey string) (int, error)
+	// Posts returns the posts of the given user. The query is artificially
+	// slowed down to take about sleep.
+	Posts(ctx context.Context, userID int, sleep time.Duration) ([]*Post, error)
+	// InsertTransaction records a new transaction for the given user and
+	// returns its id.
+	InsertTransaction(ctx context.Context, userID int, data string) (int, error)
+}
+
+// latencyDists holds the distributions supported by MemoryStore for
+// simulating query latencies. Each function returns a random duration with the
+// given mean.
+var latencyDists = map[string]func(mean time.Duration) time.Duration{
+	"fixed": func(mean time.Duration) time.Duration {
+		return mean
+	},
+	"uniform": func(mean time.Duration) time.Duration {
+		return time.Duration(rand.Float64() * 2 * float64(mean))
+	},
+	"normal": func(mean time.Duration) time.Duration {
+		d := time.Duration((rand.NormFloat64()/4 + 1) * float64(mean))
+		if d < 0 {
+			return 0
+		}
+		return d
+	},
+	"exponential": func(mean time.Duration) time.Duration {
+		return time.Duration(rand.ExpFloat64() * float64(mean))
+	},
+}
+
+// latencyDistNames returns the names of all supported latency distributions.
+func latencyDistNames() []string {
+	var names []string
+	for name := range latencyDists {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// MemoryStore is a Store that serves the same data as the schema files from
+// memory, so the app can be run without a database server.
+type MemoryStore struct {
+	latency func(time.Duration) time.Duration
+
+	mu    sync.Mutex
+	users map[string]int
+	posts []*Post
+	// lastTxID is the id of the last inserted transaction. The transactions
+	// themselves are not kept to avoid growing the heap without bounds.
+	lastTxID int
+}
+
+// NewMemoryStore returns a new MemoryStore that simulates query latencies
+// using the latency distribution with the given name.
+func NewMemoryStore(latencyDist string) (*MemoryStore, error) {
+	latency, ok := latencyDists[latencyDist]
+	if !ok {
+		return nil, fmt.Errorf("unknown latency distribution: %q", latencyDist)
+	}
+
+	s := &MemoryStore{
+		latency: latency,
+		users:   map[string]int{seedAPIKey: 1},
+	}
+	for i := 1; i <= 100; i++ {
+		s.posts = append(s.posts, &Post{
+			ID:     i,
+			UserID: 1,
+			Title:  fmt.Sprintf("Post %d", i),
+			Body:   fmt.Sprintf("Lorem %d %s", i, loremIpsum),
+		})
+	}
+	return s, nil
+}
+
+func (s *MemoryStore) UserID(ctx context.Context, apiKey string) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	userID, ok := s.users[apiKey]
+	if !ok {
+		return 0, sql.ErrNoRows
+	}
+	return userID, nil
+}
+
+func (s *MemoryStore) Posts(ctx context.Context, userID int, sleep time.Duration) ([]*Post, error) {
+	if err := s.sleep(ctx, s.latency(sleep)); err != nil {
+		return nil, err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	// Return copies, just like a database would.
+	var posts []*Post
+	for _, p := range s.posts {
+		if p.UserID == userID {
+			post := *p
+			posts = append(posts, &post)
+		}
+	}
+	return posts, nil
+}
+
+func (s *MemoryStore) InsertTransaction(ctx context.Context, userID int, data string) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.lastTxID++
+	return s.lastTxID, nil
+}
+
+// sleep blocks for d or until ctx is done.
+func (s *MemoryStore) sleep(ctx context.Context, d time.Duration) error {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
@@ -0,0 +1,76 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"math"
+	"testing"
+	"time"
+)
+
+func Test_MemoryStore(t *testing.T) {
+	s, err := NewMemoryStore("fixed")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx := context.Background()
+	userID, err := s.UserID(ctx, seedAPIKey)
+	if err != nil {
+		t.Fatal(err)
+	} else if userID != 1 {
+		t.Fatalf("got=%d want=%d", userID, 1)
+	}
+	if _, err := s.UserID(ctx, "invalid"); err != sql.ErrNoRows {
+		t.Fatalf("got=%v want=%v", err, sql.ErrNoRows)
+	}
+
+	start := time.Now()
+	posts, err := s.Posts(ctx, userID, 20*time.Millisecond)
+	if err != nil {
+		t.Fatal(err)
+	} else if len(posts) != 100 {
+		t.Fatalf("got=%d want=%d", len(posts), 100)
+	} else if posts[99].Title != "Post 100" {
+		t.Fatalf("got=%q want=%q", posts[99].Title, "Post 100")
+	}
+	if dt := time.Since(start); dt < 20*time.Millisecond {
+		t.Fatalf("query took %s, expected at least 20ms", dt)
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	cancel()
+	if _, err := s.Posts(ctx, userID, time.Hour); err != context.Canceled {
+		t.Fatalf("got=%v want=%v", err, context.Canceled)
+	}
+
+	for want := 1; want <= 2; want++ {
+		if txID, err := s.InsertTransaction(ctx, userID, "foo"); err != nil {
+			t.Fatal(err)
+		} else if txID != want {
+			t.Fatalf("got=%d want=%d", txID, want)
+		}
+	}
+
+	if _, err := NewMemoryStore("foo"); err == nil {
+		t.Fatalf("expected error for unknown latency distribution")
+	}
+}
+
+func Test_latencyDists(t *testing.T) {
+	const mean = 10 * time.Millisecond
+	for name, dist := range latencyDists {
+		var sum time.Duration
+		const n = 10000
+		for i := 0; i < n; i++ {
+			d := dist(mean)
+			if d < 0 {
+				t.Fatalf("%s: negative latency: %s", name, d)
+			}
+			sum += d
+		}
+		if got := sum / n; math.Abs(float64(got-mean)) > float64(mean)/10 {
+			t.Fatalf("%s: got=%s want=%s", name, got, mean)
+		}
+	}
+}
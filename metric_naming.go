@@ -0,0 +1,113 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"regexp"
+	"runtime/metrics"
+	"sort"
+	"strings"
+)
+
+// metricNamer returns the name under which a runtime/metrics metric is
+// reported. stat is empty for the metric itself, or the name of a derived
+// value such as avg, p99 or overflow for histograms.
+type metricNamer func(desc metrics.Description, stat string) (string, error)
+
+// metricNamers are the naming schemes selectable via -metrics.naming. The
+// names of memstats are not affected by the scheme.
+var metricNamers = map[string]metricNamer{
+	// e.g. runtime.go.metrics.gc.heap.allocs.bytes, this app's original
+	// scheme.
+	"current": currentMetricName,
+	// e.g. runtime.go.metrics.gc_heap_allocs.bytes, the scheme used by the
+	// dd-trace-go runtime metrics.
+	"datadog": datadogMetricName,
+	// e.g. go_gc_heap_allocs_bytes_total, same as the /metrics endpoint.
+	"prometheus": prometheusMetricName,
+}
+
+// metricNamerNames returns the sorted names of all metricNamers.
+func metricNamerNames() []string {
+	var names []string
+	for name := range metricNamers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func currentMetricName(desc metrics.Description, stat string) (string, error) {
+	name, unit, err := metricName(desc.Name)
+	if err != nil {
+		return "", err
+	}
+	if stat != "" {
+		name += "." + stat
+	}
+	return name + "." + unit, nil
+}
+
+func datadogMetricName(desc metrics.Description, stat string) (string, error) {
+	path, unit, err := parseMetricName(desc.Name)
+	if err != nil {
+		return "", err
+	}
+	name := "runtime.go.metrics." + strings.ReplaceAll(path, "/", "_") + "." + unit
+	if stat != "" {
+		name += "." + stat
+	}
+	return name, nil
+}
+
+func prometheusMetricName(desc metrics.Description, stat string) (string, error) {
+	if _, _, err := parseMetricName(desc.Name); err != nil {
+		return "", err
+	}
+	name := openMetricsName(desc.Name)
+	if stat != "" {
+		name += "_" + openMetricsInvalidChars.ReplaceAllString(stat, "_")
+	} else if desc.Cumulative && desc.Kind != metrics.KindFloat64Histogram {
+		name += "_total"
+	}
+	return name, nil
+}
+
+// namedMetrics returns the subset of descs that can be named by namer along
+// with their names. Metrics that can't be named are logged and dropped, so
+// they're never reported under a bogus name.
+func namedMetrics(descs []metrics.Description, namer metricNamer) ([]metrics.Description, []string) {
+	var named []metrics.Description
+	var names []string
+	for _, desc := range descs {
+		name, err := namer(desc, "")
+		if err != nil {
+			log.Printf("Not reporting runtime metric: %s", err)
+			continue
+		}
+		named = append(named, desc)
+		names = append(names, name)
+	}
+	return named, names
+}
+
+var metricRegex = regexp.MustCompile("^(?P<name>/[^:]+):(?P<unit>[^:*/]+(?:[*/][^:*/]+)*)$")
+
+// parseMetricName splits a runtime/metrics name like /gc/heap/allocs:bytes
+// into its path without leading slash and its unit, e.g. gc/heap/allocs and
+// bytes.
+func parseMetricName(runtimeName string) (path, unit string, err error) {
+	m := metricRegex.FindStringSubmatch(runtimeName)
+	if len(m) != 3 {
+		return "", "", fmt.Errorf("can't parse metric name: %q", runtimeName)
+	}
+	return m[1][1:], m[2], nil
+}
+
+func metricName(runtimeName string) (string, string, error) {
+	path, unit, err := parseMetricName(runtimeName)
+	if err != nil {
+		return "", "", err
+	}
+	return "runtime.go.metrics." + strings.ReplaceAll(path, "/", "."), unit, nil
+}
@@ -0,0 +1,61 @@
+package main
+
+import (
+	"runtime/metrics"
+	"testing"
+)
+
+func Test_metricNamers(t *testing.T) {
+	allocs := metrics.Description{Name: "/gc/heap/allocs:bytes", Kind: metrics.KindUint64, Cumulative: true}
+	goroutines := metrics.Description{Name: "/sched/goroutines:goroutines", Kind: metrics.KindUint64}
+	latencies := metrics.Description{Name: "/sched/latencies:seconds", Kind: metrics.KindFloat64Histogram, Cumulative: true}
+	cpu := metrics.Description{Name: "/cpu/classes/gc/mark/assist:cpu-seconds", Kind: metrics.KindFloat64, Cumulative: true}
+
+	tests := []struct {
+		Namer metricNamer
+		Desc  metrics.Description
+		Stat  string
+		Want  string
+	}{
+		{currentMetricName, allocs, "", "runtime.go.metrics.gc.heap.allocs.bytes"},
+		{currentMetricName, latencies, "p99", "runtime.go.metrics.sched.latencies.p99.seconds"},
+		{datadogMetricName, allocs, "", "runtime.go.metrics.gc_heap_allocs.bytes"},
+		{datadogMetricName, latencies, "p99", "runtime.go.metrics.sched_latencies.seconds.p99"},
+		{datadogMetricName, cpu, "", "runtime.go.metrics.cpu_classes_gc_mark_assist.cpu-seconds"},
+		{prometheusMetricName, allocs, "", "go_gc_heap_allocs_bytes_total"},
+		{prometheusMetricName, goroutines, "", "go_sched_goroutines_goroutines"},
+		{prometheusMetricName, latencies, "", "go_sched_latencies_seconds"},
+		{prometheusMetricName, latencies, "p99.9", "go_sched_latencies_seconds_p99_9"},
+		{prometheusMetricName, cpu, "", "go_cpu_classes_gc_mark_assist_cpu_seconds_total"},
+	}
+	for _, test := range tests {
+		got, err := test.Namer(test.Desc, test.Stat)
+		if err != nil {
+			t.Fatal(err)
+		} else if got != test.Want {
+			t.Fatalf("got=%s want=%s", got, test.Want)
+		}
+	}
+
+	for name, namer := range metricNamers {
+		if _, err := namer(metrics.Description{Name: "bogus"}, ""); err == nil {
+			t.Fatalf("%s: expected error", name)
+		}
+		// All current runtime metrics should be nameable.
+		if descs, _ := namedMetrics(metrics.All(), namer); len(descs) != len(metrics.All()) {
+			t.Fatalf("%s: got=%d want=%d", name, len(descs), len(metrics.All()))
+		}
+	}
+}
+
+func Test_namedMetrics(t *testing.T) {
+	descs, names := namedMetrics([]metrics.Description{
+		{Name: "/gc/heap/allocs:bytes"},
+		{Name: "/no/unit"},
+	}, currentMetricName)
+	if len(descs) != 1 || len(names) != 1 {
+		t.Fatalf("got=%d want=%d", len(descs), 1)
+	} else if names[0] != "runtime.go.metrics.gc.heap.allocs.bytes" {
+		t.Fatalf("got=%s want=%s", names[0], "runtime.go.metrics.gc.heap.allocs.bytes")
+	}
+}
@@ -1,43 +1,49 @@
 package main
 
 import (
+	"context"
 	"fmt"
+	"log"
 	"math"
+	"regexp"
 	"runtime/metrics"
+	"strings"
 	"time"
-
-	"github.com/DataDog/datadog-go/statsd"
 )
 
-func reportRuntimeMetrics(statsd statsd.ClientInterface) {
-	descs := metrics.All()
+// reportRuntimeMetrics reports the given runtime/metrics every interval, see
+// filterMetrics. Metrics are named by namer.
+func reportRuntimeMetrics(ctx context.Context, sink MetricsSink, descs []metrics.Description, namer metricNamer, interval time.Duration) {
+	descs, names := namedMetrics(descs, namer)
 	samples := make([]metrics.Sample, len(descs))
 	for i := range samples {
 		samples[i].Name = descs[i].Name
 	}
 
-	m := map[string]*histDist{}
+	hds := make([]histDist, len(samples))
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
 	for {
 		metrics.Read(samples)
-		for _, sample := range samples {
-			name, value := sample.Name, sample.Value
-			name, unit := metricName(name)
+		for i, sample := range samples {
+			name, value := names[i], sample.Value
 
 			switch value.Kind() {
 			case metrics.KindUint64:
-				statsd.Gauge(name+"."+unit, float64(value.Uint64()), nil, 1)
+				sink.Gauge(name, float64(value.Uint64()))
 			case metrics.KindFloat64:
-				statsd.Gauge(name+"."+unit, value.Float64(), nil, 1)
+				sink.Gauge(name, value.Float64())
 			case metrics.KindFloat64Histogram:
-				key := name + "." + unit
-				hd, ok := m[key]
-				if !ok {
-					hd = &histDist{}
-					m[key] = hd
-				}
-				for _, e := range hd.Update(value.Float64Histogram()) {
-					statsd.Distribution(key, e.Value, nil, float64(e.Count))
+				hg := value.Float64Histogram()
+				for _, e := range hds[i].Update(hg) {
+					sink.Distribution(name, e.Value, e.Count)
 				}
+				under, over := histOutOfRange(hg)
+				// Can't fail, the metric was named by namedMetrics.
+				underName, _ := namer(descs[i], "underflow")
+				overName, _ := namer(descs[i], "overflow")
+				sink.Gauge(underName, float64(under))
+				sink.Gauge(overName, float64(over))
 			case metrics.KindBad:
 				// This should never happen because all metrics are supported
 				// by construction.
@@ -51,10 +57,62 @@ func reportRuntimeMetrics(statsd statsd.ClientInterface) {
 				fmt.Printf("%s: unexpected metric Kind: %v\n", name, value.Kind())
 			}
 		}
-		time.Sleep(10 * time.Second)
+
+		if err := sink.Flush(); err != nil {
+			log.Printf("Failed to flush runtime metrics: %s", err)
+		}
+
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			return
+		}
 	}
 }
 
+// filterMetrics returns all supported runtime/metrics whose name matches at
+// least one of the include patterns and none of the exclude patterns. All
+// metrics are included if include is empty. Patterns are matched against the
+// full name, e.g. /gc/heap/allocs:bytes, and * matches any sequence of
+// characters including /, e.g. /gc/* or *:seconds.
+func filterMetrics(include, exclude []string) []metrics.Description {
+	includeRe, excludeRe := globsRegexp(include), globsRegexp(exclude)
+	var descs []metrics.Description
+	for _, desc := range metrics.All() {
+		if includeRe != nil && !includeRe.MatchString(desc.Name) {
+			continue
+		} else if excludeRe != nil && excludeRe.MatchString(desc.Name) {
+			continue
+		}
+		descs = append(descs, desc)
+	}
+	return descs
+}
+
+// globsRegexp returns a regexp that matches if any of the glob patterns
+// matches, or nil if there are no patterns.
+func globsRegexp(globs []string) *regexp.Regexp {
+	if len(globs) == 0 {
+		return nil
+	}
+	alternatives := make([]string, len(globs))
+	for i, glob := range globs {
+		alternatives[i] = strings.ReplaceAll(regexp.QuoteMeta(glob), `\*`, ".*")
+	}
+	return regexp.MustCompile("^(?:" + strings.Join(alternatives, "|") + ")$")
+}
+
+// splitList splits a comma separated list and drops empty elements.
+func splitList(val string) []string {
+	var list []string
+	for _, field := range strings.Split(val, ",") {
+		if field = strings.TrimSpace(field); field != "" {
+			list = append(list, field)
+		}
+	}
+	return list
+}
+
 // histDist converts metrics.Float64Histogram values into
 type histDist struct {
 	prev *metrics.Float64Histogram
@@ -67,10 +125,6 @@ type histEvent struct {
 
 func (h *histDist) Update(hg *metrics.Float64Histogram) (events []histEvent) {
 	for i, count := range hg.Counts {
-		min, max := hg.Buckets[i], hg.Buckets[i+1]
-		if math.IsInf(min, 0) || math.IsInf(max, 0) {
-			continue
-		}
 		diff := count
 		if h.prev != nil {
 			diff = count - h.prev.Counts[i]
@@ -81,13 +135,44 @@ func (h *histDist) Update(hg *metrics.Float64Histogram) (events []histEvent) {
 
 		events = append(events, histEvent{
 			Count: diff,
-			Value: (min + max) / 2,
+			Value: histBucketValue(hg.Buckets[i], hg.Buckets[i+1]),
 		})
 	}
 	h.prev = cloneFloat64Histogram(hg)
 	return
 }
 
+// histBucketValue returns the value used to represent the samples of the
+// bucket [min, max). This is the midpoint of finite buckets. Samples in the
+// open-ended buckets at either end of a runtime/metrics histogram are clamped
+// to the adjacent finite bound, so they're still counted, and
+// histOutOfRange tells how many of them there are.
+func histBucketValue(min, max float64) float64 {
+	switch {
+	case math.IsInf(min, -1) && math.IsInf(max, 1):
+		return 0
+	case math.IsInf(min, -1):
+		return max
+	case math.IsInf(max, 1):
+		return min
+	}
+	return (min + max) / 2
+}
+
+// histOutOfRange returns the number of samples that landed in the
+// open-ended buckets below and above the finite range of hg.
+func histOutOfRange(hg *metrics.Float64Histogram) (under, over uint64) {
+	for i, count := range hg.Counts {
+		if math.IsInf(hg.Buckets[i], -1) {
+			under += count
+		}
+		if math.IsInf(hg.Buckets[i+1], 1) {
+			over += count
+		}
+	}
+	return
+}
+
 func cloneFloat64Histogram(hg *metrics.Float64Histogram) *metrics.Float64Histogram {
 	clone := &metrics.Float64Histogram{
 		Counts:  make([]uint64, len(hg.Counts)),
@@ -100,14 +185,3 @@ func cloneFloat64Histogram(hg *metrics.Float64Histogram) *metrics.Float64Histogr
 	}
 	return clone
 }
-
-//func (h *metrics.Float64Histogram) (s histStats) {
-//// TODO(fg) very inefficent implementation
-//s.Avg = histAvg(h)
-//s.Min = histPercentile(h, 0)
-//s.Median = histPercentile(h, 0.5)
-//s.P95 = histPercentile(h, 0.95)
-//s.P99 = histPercentile(h, 0.99)
-//s.Max = histPercentile(h, 1)
-//return
-//}
@@ -0,0 +1,167 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/DataDog/datadog-go/statsd"
+)
+
+// MetricsSink receives the metrics produced by reportRuntimeMetrics and
+// reportMemstats. Implementations must be safe for concurrent use.
+type MetricsSink interface {
+	// Gauge records the current value of a metric.
+	Gauge(name string, value float64)
+	// Distribution records count observations of value.
+	Distribution(name string, value float64, count uint64)
+	// Flush is called after every reporting cycle.
+	Flush() error
+	// Close flushes and releases the sink.
+	Close() error
+}
+
+// metricsSinkNames are the values accepted by newMetricsSink.
+var metricsSinkNames = []string{"statsd", "file", "stdout", "none"}
+
+// newMetricsSink returns the sink with the given name. path is only used by
+// the file sink and client only by the statsd sink. The statsd client is
+// shared with the profiler, so it's not closed by the sink. tags are attached
+// to every metric by the statsd and file sinks.
+func newMetricsSink(name, path string, client *statsd.Client, tags []string) (MetricsSink, error) {
+	switch name {
+	case "statsd":
+		if client == nil {
+			return nil, fmt.Errorf("no statsd client available")
+		}
+		return &statsdSink{client: client, tags: tags}, nil
+	case "file":
+		if path == "" {
+			return nil, fmt.Errorf("file metrics sink requires a path")
+		}
+		f, err := os.Create(path)
+		if err != nil {
+			return nil, err
+		}
+		return newJSONSink(f, tags), nil
+	case "stdout":
+		return newTextSink(os.Stdout), nil
+	case "none":
+		return nopSink{}, nil
+	default:
+		return nil, fmt.Errorf("unknown metrics sink: %q", name)
+	}
+}
+
+// statsdSink sends metrics to the datadog agent.
+type statsdSink struct {
+	client statsd.ClientInterface
+	tags   []string
+}
+
+func (s *statsdSink) Gauge(name string, value float64) {
+	s.client.Gauge(name, value, s.tags, 1)
+}
+
+func (s *statsdSink) Distribution(name string, value float64, count uint64) {
+	s.client.Distribution(name, value, s.tags, float64(count))
+}
+
+func (s *statsdSink) Flush() error { return s.client.Flush() }
+
+func (s *statsdSink) Close() error { return s.client.Flush() }
+
+// jsonSink writes one JSON object per metric and line to w.
+type jsonSink struct {
+	mu   sync.Mutex
+	w    io.WriteCloser
+	bw   *bufio.Writer
+	enc  *json.Encoder
+	tags []string
+}
+
+type jsonMetric struct {
+	Time  time.Time `json:"time"`
+	Type  string    `json:"type"`
+	Name  string    `json:"name"`
+	Value float64   `json:"value"`
+	Count uint64    `json:"count,omitempty"`
+	Tags  []string  `json:"tags,omitempty"`
+}
+
+func newJSONSink(w io.WriteCloser, tags []string) *jsonSink {
+	bw := bufio.NewWriter(w)
+	return &jsonSink{w: w, bw: bw, enc: json.NewEncoder(bw), tags: tags}
+}
+
+func (s *jsonSink) Gauge(name string, value float64) {
+	s.write(jsonMetric{Time: time.Now(), Type: "gauge", Name: name, Value: value})
+}
+
+func (s *jsonSink) Distribution(name string, value float64, count uint64) {
+	s.write(jsonMetric{Time: time.Now(), Type: "distribution", Name: name, Value: value, Count: count})
+}
+
+func (s *jsonSink) write(m jsonMetric) {
+	m.Tags = s.tags
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	// Errors are reported by Flush.
+	s.enc.Encode(m)
+}
+
+func (s *jsonSink) Flush() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.bw.Flush()
+}
+
+func (s *jsonSink) Close() error {
+	if err := s.Flush(); err != nil {
+		s.w.Close()
+		return err
+	}
+	return s.w.Close()
+}
+
+// textSink writes metrics in a human readable format to w.
+type textSink struct {
+	mu sync.Mutex
+	bw *bufio.Writer
+}
+
+func newTextSink(w io.Writer) *textSink {
+	return &textSink{bw: bufio.NewWriter(w)}
+}
+
+func (s *textSink) Gauge(name string, value float64) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	fmt.Fprintf(s.bw, "%s %-70s %g\n", time.Now().Format("15:04:05"), name, value)
+}
+
+func (s *textSink) Distribution(name string, value float64, count uint64) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	fmt.Fprintf(s.bw, "%s %-70s %g x %d\n", time.Now().Format("15:04:05"), name, value, count)
+}
+
+func (s *textSink) Flush() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.bw.Flush()
+}
+
+func (s *textSink) Close() error { return s.Flush() }
+
+// nopSink discards all metrics.
+type nopSink struct{}
+
+func (nopSink) Gauge(string, float64)                {}
+func (nopSink) Distribution(string, float64, uint64) {}
+func (nopSink) Flush() error                         { return nil }
+func (nopSink) Close() error                         { return nil }
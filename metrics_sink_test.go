@@ -0,0 +1,107 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+type nopCloser struct{ *bytes.Buffer }
+
+func (nopCloser) Close() error { return nil }
+
+func Test_jsonSink(t *testing.T) {
+	var buf bytes.Buffer
+	sink := newJSONSink(nopCloser{&buf}, []string{"env:test"})
+	sink.Gauge("go.memstats.alloc", 42)
+	sink.Distribution("go.gc.pauses.seconds", 0.5, 3)
+	if buf.Len() != 0 {
+		t.Fatalf("got=%q want=%q before flush", buf.String(), "")
+	}
+	if err := sink.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	var got []jsonMetric
+	s := bufio.NewScanner(&buf)
+	for s.Scan() {
+		var m jsonMetric
+		if err := json.Unmarshal(s.Bytes(), &m); err != nil {
+			t.Fatal(err)
+		}
+		got = append(got, m)
+	}
+	if len(got) != 2 {
+		t.Fatalf("got=%d want=%d", len(got), 2)
+	} else if m := got[0]; m.Type != "gauge" || m.Name != "go.memstats.alloc" || m.Value != 42 || len(m.Tags) != 1 {
+		t.Fatalf("unexpected gauge: %+v", m)
+	} else if m := got[1]; m.Type != "distribution" || m.Value != 0.5 || m.Count != 3 {
+		t.Fatalf("unexpected distribution: %+v", m)
+	}
+}
+
+func Test_textSink(t *testing.T) {
+	var buf bytes.Buffer
+	sink := newTextSink(&buf)
+	sink.Gauge("go.memstats.alloc", 42)
+	sink.Distribution("go.gc.pauses.seconds", 0.5, 3)
+	if err := sink.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	if len(lines) != 2 {
+		t.Fatalf("got=%d want=%d", len(lines), 2)
+	} else if f := strings.Fields(lines[0]); len(f) != 3 || f[1] != "go.memstats.alloc" || f[2] != "42" {
+		t.Fatalf("unexpected line: %q", lines[0])
+	} else if !strings.HasSuffix(lines[1], "0.5 x 3") {
+		t.Fatalf("unexpected line: %q", lines[1])
+	}
+}
+
+func Test_newMetricsSink(t *testing.T) {
+	for _, name := range []string{"statsd", "file", "bogus"} {
+		if _, err := newMetricsSink(name, "", nil, nil); err == nil {
+			t.Fatalf("%s: expected error", name)
+		}
+	}
+}
+
+// recordingSink remembers the names of all metrics it receives.
+type recordingSink struct {
+	mu      sync.Mutex
+	names   map[string]bool
+	flushes int
+}
+
+func (s *recordingSink) Gauge(name string, value float64) { s.record(name) }
+
+func (s *recordingSink) Distribution(name string, value float64, count uint64) { s.record(name) }
+
+func (s *recordingSink) record(name string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.names[name] = true
+}
+
+func (s *recordingSink) Flush() error { s.flushes++; return nil }
+
+func (s *recordingSink) Close() error { return nil }
+
+func Test_reportRuntimeMetrics(t *testing.T) {
+	sink := &recordingSink{names: map[string]bool{}}
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	// Reports once and returns because ctx is done.
+	reportRuntimeMetrics(ctx, sink, filterMetrics(nil, nil), currentMetricName, time.Second)
+	if sink.flushes != 1 {
+		t.Fatalf("got=%d want=%d", sink.flushes, 1)
+	}
+	if !sink.names["runtime.go.metrics.gc.heap.allocs.bytes"] {
+		t.Fatalf("missing runtime.go.metrics.gc.heap.allocs.bytes in %v", sink.names)
+	}
+}
@@ -1,8 +1,10 @@
 package main
 
 import (
+	"math"
 	"reflect"
 	"runtime/metrics"
+	"strings"
 	"testing"
 )
 
@@ -36,6 +38,21 @@ func Test_histDist_Update(t *testing.T) {
 				},
 			},
 		},
+		{
+			In: []*metrics.Float64Histogram{
+				{
+					Counts:  []uint64{4, 1, 2},
+					Buckets: []float64{math.Inf(-1), 1, 11, math.Inf(1)},
+				},
+			},
+			Want: [][]histEvent{
+				{
+					{1, 4},
+					{float64(1+11) / 2, 1},
+					{11, 2},
+				},
+			},
+		},
 	}
 
 	for _, test := range tests {
@@ -49,3 +66,32 @@ func Test_histDist_Update(t *testing.T) {
 	}
 
 }
+
+func Test_filterMetrics(t *testing.T) {
+	all := filterMetrics(nil, nil)
+	if len(all) != len(metrics.All()) {
+		t.Fatalf("got=%d want=%d", len(all), len(metrics.All()))
+	}
+
+	got := filterMetrics([]string{"/gc/*", "/sched/latencies:seconds"}, []string{"*:objects", "/gc/heap/*"})
+	want := map[string]bool{"/sched/latencies:seconds": true, "/gc/cycles/total:gc-cycles": true}
+	for _, desc := range got {
+		name := desc.Name
+		if name != "/sched/latencies:seconds" && !strings.HasPrefix(name, "/gc/") {
+			t.Fatalf("unexpected metric: %s", name)
+		} else if strings.HasSuffix(name, ":objects") || strings.HasPrefix(name, "/gc/heap/") {
+			t.Fatalf("unexpected metric: %s", name)
+		}
+		delete(want, name)
+	}
+	if len(want) != 0 {
+		t.Fatalf("missing metrics: %v", want)
+	}
+}
+
+func Test_splitList(t *testing.T) {
+	got := splitList(" /gc/*, ,/sched/* ")
+	if want := []string{"/gc/*", "/sched/*"}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("got=%v want=%v", got, want)
+	}
+}
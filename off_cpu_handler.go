@@ -0,0 +1,205 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"sync"
+	"time"
+)
+
+// OffCPUHandler spends its time in explicit off-CPU waits of different kinds,
+// so they can be told apart in wall clock and block profiles. Unlike the
+// pg_sleep of PostsHandler.ioWork, every wait is done by a separate function:
+//
+//	sleepWait:    time.Sleep
+//	chanRecvWait: blocking channel receive
+//	selectWait:   select with a timeout
+//	pipeReadWait: blocking read syscall on a pipe
+//	upstreamWait: HTTP call to a slow upstream server
+//
+// The waits run one after another. The following query parameters override
+// the durations given by the handler fields, 0 skips a wait:
+//
+//	sleep, chanRecv, select, pipeRead, upstream
+type OffCPUHandler struct {
+	DB       Store
+	Sleep    time.Duration
+	ChanRecv time.Duration
+	Select   time.Duration
+	PipeRead time.Duration
+	Upstream time.Duration
+	// UpstreamURL is called for the Upstream wait with the duration as delay
+	// query parameter, see UpstreamStubHandler. If empty, an
+	// UpstreamStubHandler served on a random local port is used.
+	UpstreamURL string
+}
+
+func (h OffCPUHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if _, ok := auth(h.DB, w, r); !ok {
+		return
+	}
+
+	waits := []struct {
+		Name     string
+		Duration time.Duration
+		Wait     func(context.Context, time.Duration) error
+	}{
+		{"sleep", h.Sleep, sleepWait},
+		{"chanRecv", h.ChanRecv, chanRecvWait},
+		{"select", h.Select, selectWait},
+		{"pipeRead", h.PipeRead, pipeReadWait},
+		{"upstream", h.Upstream, func(ctx context.Context, d time.Duration) error {
+			return upstreamWait(ctx, h.UpstreamURL, d)
+		}},
+	}
+	q := r.URL.Query()
+	for i, wait := range waits {
+		if val := q.Get(wait.Name); val != "" {
+			d, err := time.ParseDuration(val)
+			if err != nil || d < 0 {
+				respondErr(w, http.StatusBadRequest, "bad %s: %q\n", wait.Name, val)
+				return
+			}
+			waits[i].Duration = d
+		}
+	}
+
+	var buf bytes.Buffer
+	for _, wait := range waits {
+		if wait.Duration == 0 {
+			continue
+		}
+		start := time.Now()
+		ctx, end := startPhase(r.Context(), wait.Name)
+		err := wait.Wait(ctx, wait.Duration)
+		end(err)
+		if err != nil {
+			respondErr(w, http.StatusInternalServerError, "%s: %s\n", wait.Name, err)
+			return
+		}
+		fmt.Fprintf(&buf, "%s: %s\n", wait.Name, time.Since(start))
+	}
+	w.Write(buf.Bytes())
+}
+
+//go:noinline
+func sleepWait(_ context.Context, d time.Duration) error {
+	time.Sleep(d)
+	return nil
+}
+
+//go:noinline
+func chanRecvWait(_ context.Context, d time.Duration) error {
+	ch := make(chan struct{})
+	time.AfterFunc(d, func() { close(ch) })
+	<-ch
+	return nil
+}
+
+//go:noinline
+func selectWait(ctx context.Context, d time.Duration) error {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+//go:noinline
+func pipeReadWait(_ context.Context, d time.Duration) error {
+	r, w, err := os.Pipe()
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+	defer w.Close()
+	// Fd puts the pipe into blocking mode, so the read below blocks in the
+	// read syscall rather than parking the goroutine in the netpoller.
+	r.Fd()
+	time.AfterFunc(d, func() { w.Write([]byte{0}) })
+	_, err = r.Read(make([]byte, 1))
+	return err
+}
+
+//go:noinline
+func upstreamWait(ctx context.Context, upstreamURL string, d time.Duration) error {
+	if upstreamURL == "" {
+		var err error
+		if upstreamURL, err = localUpstreamURL(); err != nil {
+			return err
+		}
+	}
+	u, err := url.Parse(upstreamURL)
+	if err != nil {
+		return err
+	}
+	q := u.Query()
+	q.Set("delay", d.String())
+	u.RawQuery = q.Encode()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u.String(), nil)
+	if err != nil {
+		return err
+	}
+	res, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	if _, err := io.Copy(ioutil.Discard, res.Body); err != nil {
+		return err
+	} else if res.StatusCode != http.StatusOK {
+		return fmt.Errorf("upstream: unexpected status: %s", res.Status)
+	}
+	return nil
+}
+
+// localUpstream is the UpstreamStubHandler used by OffCPUHandler if no
+// UpstreamURL is configured. It's started on first use.
+var localUpstream struct {
+	once sync.Once
+	url  string
+	err  error
+}
+
+func localUpstreamURL() (string, error) {
+	localUpstream.once.Do(func() {
+		ln, err := net.Listen("tcp", "127.0.0.1:0")
+		if err != nil {
+			localUpstream.err = err
+			return
+		}
+		go http.Serve(ln, UpstreamStubHandler{})
+		localUpstream.url = "http://" + ln.Addr().String() + "/"
+	})
+	return localUpstream.url, localUpstream.err
+}
+
+// UpstreamStubHandler simulates a slow upstream server. It responds after
+// the duration given by the delay query parameter, e.g. ?delay=50ms.
+type UpstreamStubHandler struct{}
+
+func (UpstreamStubHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	d, err := time.ParseDuration(r.URL.Query().Get("delay"))
+	if err != nil {
+		respondErr(w, http.StatusBadRequest, "bad delay: %s\n", err)
+		return
+	}
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		fmt.Fprintf(w, "ok\n")
+	case <-r.Context().Done():
+	}
+}
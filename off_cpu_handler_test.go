@@ -0,0 +1,50 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+)
+
+func Test_OffCPUHandler(t *testing.T) {
+	store, err := NewMemoryStore("fixed")
+	if err != nil {
+		t.Fatal(err)
+	}
+	h := OffCPUHandler{DB: store, Sleep: 10 * time.Millisecond, Upstream: 10 * time.Millisecond}
+	do := func(q url.Values) *httptest.ResponseRecorder {
+		q.Set("key", seedAPIKey)
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, httptest.NewRequest("GET", "/off-cpu?"+q.Encode(), nil))
+		return rec
+	}
+
+	start := time.Now()
+	rec := do(url.Values{"chanRecv": {"10ms"}, "select": {"10ms"}, "pipeRead": {"10ms"}})
+	if rec.Code != http.StatusOK {
+		t.Fatalf("got=%d want=%d: %s", rec.Code, http.StatusOK, rec.Body)
+	} else if got, want := time.Since(start), 50*time.Millisecond; got < want {
+		t.Fatalf("got=%s want>=%s", got, want)
+	}
+	for _, name := range []string{"sleep", "chanRecv", "select", "pipeRead", "upstream"} {
+		if !strings.Contains(rec.Body.String(), name+": ") {
+			t.Fatalf("missing %s in %q", name, rec.Body)
+		}
+	}
+
+	rec = do(url.Values{"sleep": {"0"}, "upstream": {"0"}})
+	if rec.Code != http.StatusOK {
+		t.Fatalf("got=%d want=%d: %s", rec.Code, http.StatusOK, rec.Body)
+	} else if rec.Body.Len() != 0 {
+		t.Fatalf("got=%q want no waits", rec.Body)
+	}
+
+	for _, q := range []url.Values{{"sleep": {"x"}}, {"select": {"-1ms"}}} {
+		if rec := do(q); rec.Code != http.StatusBadRequest {
+			t.Fatalf("%v: got=%d want=%d", q, rec.Code, http.StatusBadRequest)
+		}
+	}
+}
@@ -0,0 +1,137 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"regexp"
+	"runtime"
+	"runtime/metrics"
+	"strconv"
+	"strings"
+)
+
+const openMetricsContentType = "application/openmetrics-text; version=1.0.0; charset=utf-8"
+
+// MetricsHandler exposes all runtime/metrics samples and the runtime.MemStats
+// gauges in the OpenMetrics text format, so they can be scraped by
+// Prometheus.
+type MetricsHandler struct{}
+
+func (h MetricsHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", openMetricsContentType)
+	if err := writeOpenMetrics(w); err != nil {
+		respondErr(w, http.StatusInternalServerError, "writeOpenMetrics: %s\n", err)
+	}
+}
+
+// writeOpenMetrics writes the current value of all runtime metrics and
+// memstats to w.
+func writeOpenMetrics(w io.Writer) error {
+	bw := bufio.NewWriter(w)
+
+	descs := metrics.All()
+	samples := make([]metrics.Sample, len(descs))
+	for i := range samples {
+		samples[i].Name = descs[i].Name
+	}
+	metrics.Read(samples)
+	for i, sample := range samples {
+		writeOpenMetricsSample(bw, descs[i], sample.Value)
+	}
+
+	var stats runtime.MemStats
+	runtime.ReadMemStats(&stats)
+	for _, g := range memstatsGauges {
+		name := "go_memstats_" + g.Name
+		fmt.Fprintf(bw, "# TYPE %s gauge\n", name)
+		fmt.Fprintf(bw, "%s %s\n", name, formatOpenMetricsFloat(g.Value(&stats)))
+	}
+
+	fmt.Fprintf(bw, "# EOF\n")
+	return bw.Flush()
+}
+
+// writeOpenMetricsSample writes a single runtime/metrics sample as a metric
+// family. Cumulative metrics become counters, histograms become histograms
+// with one bucket per runtime/metrics bucket, everything else is a gauge.
+func writeOpenMetricsSample(w io.Writer, desc metrics.Description, value metrics.Value) {
+	name := openMetricsName(desc.Name)
+	typ := "gauge"
+	if value.Kind() == metrics.KindFloat64Histogram {
+		typ = "histogram"
+	} else if desc.Cumulative {
+		typ = "counter"
+	}
+
+	var sample string
+	switch value.Kind() {
+	case metrics.KindUint64:
+		sample = strconv.FormatUint(value.Uint64(), 10)
+	case metrics.KindFloat64:
+		sample = formatOpenMetricsFloat(value.Float64())
+	case metrics.KindFloat64Histogram:
+	default:
+		// Unsupported or new kind of metric, see reportRuntimeMetrics.
+		return
+	}
+
+	fmt.Fprintf(w, "# TYPE %s %s\n", name, typ)
+	fmt.Fprintf(w, "# HELP %s %s\n", name, escapeOpenMetricsHelp(desc.Description))
+	switch typ {
+	case "counter":
+		fmt.Fprintf(w, "%s_total %s\n", name, sample)
+	case "gauge":
+		fmt.Fprintf(w, "%s %s\n", name, sample)
+	case "histogram":
+		writeOpenMetricsHistogram(w, name, value.Float64Histogram())
+	}
+}
+
+// writeOpenMetricsHistogram writes the cumulative bucket counts of h. The
+// upper bound of each runtime/metrics bucket is used as its le label.
+// runtime/metrics doesn't track the sum of observations, so there is no _sum
+// sample, and no _count either as OpenMetrics only allows them together. The
+// +Inf bucket holds the count.
+func writeOpenMetricsHistogram(w io.Writer, name string, h *metrics.Float64Histogram) {
+	var count uint64
+	for i, c := range h.Counts {
+		count += c
+		le := h.Buckets[i+1]
+		if math.IsInf(le, 1) {
+			// Written below.
+			continue
+		}
+		fmt.Fprintf(w, "%s_bucket{le=\"%s\"} %d\n", name, formatOpenMetricsFloat(le), count)
+	}
+	fmt.Fprintf(w, "%s_bucket{le=\"+Inf\"} %d\n", name, count)
+}
+
+var openMetricsInvalidChars = regexp.MustCompile(`[^a-zA-Z0-9_]`)
+
+// openMetricsName turns a runtime/metrics name like /gc/heap/allocs:bytes
+// into an OpenMetrics name like go_gc_heap_allocs_bytes.
+func openMetricsName(runtimeName string) string {
+	name := strings.TrimPrefix(runtimeName, "/")
+	name = strings.Replace(name, ":", "_", 1)
+	return "go_" + openMetricsInvalidChars.ReplaceAllString(name, "_")
+}
+
+func formatOpenMetricsFloat(f float64) string {
+	switch {
+	case math.IsInf(f, 1):
+		return "+Inf"
+	case math.IsInf(f, -1):
+		return "-Inf"
+	case math.IsNaN(f):
+		return "NaN"
+	}
+	return strconv.FormatFloat(f, 'g', -1, 64)
+}
+
+func escapeOpenMetricsHelp(s string) string {
+	s = strings.ReplaceAll(s, `\`, `\\`)
+	return strings.ReplaceAll(s, "\n", `\n`)
+}
@@ -0,0 +1,84 @@
+package main
+
+import (
+	"bytes"
+	"math"
+	"runtime/metrics"
+	"strings"
+	"testing"
+)
+
+func Test_writeOpenMetrics(t *testing.T) {
+	var buf bytes.Buffer
+	if err := writeOpenMetrics(&buf); err != nil {
+		t.Fatal(err)
+	}
+	out := buf.String()
+	for _, want := range []string{
+		"# TYPE go_gc_heap_allocs_bytes counter\n",
+		"\ngo_gc_heap_allocs_bytes_total ",
+		"# TYPE go_sched_goroutines_goroutines gauge\n",
+		"# TYPE go_sched_latencies_seconds histogram\n",
+		"\ngo_sched_latencies_seconds_bucket{le=\"+Inf\"} ",
+		"# TYPE go_memstats_heapinuse gauge\n",
+	} {
+		if !strings.Contains(out, want) {
+			t.Fatalf("missing %q in output", want)
+		}
+	}
+	if !strings.HasSuffix(out, "# EOF\n") {
+		t.Fatalf("output must end with # EOF")
+	}
+}
+
+func Test_writeOpenMetricsHistogram(t *testing.T) {
+	tests := []struct {
+		In   *metrics.Float64Histogram
+		Want string
+	}{
+		{
+			In: &metrics.Float64Histogram{
+				Counts:  []uint64{2, 7, 10},
+				Buckets: []float64{1, 11, 21, 31},
+			},
+			Want: `foo_bucket{le="11"} 2
+foo_bucket{le="21"} 9
+foo_bucket{le="31"} 19
+foo_bucket{le="+Inf"} 19
+`,
+		},
+		{
+			In: &metrics.Float64Histogram{
+				Counts:  []uint64{1, 2, 3},
+				Buckets: []float64{math.Inf(-1), 0.5, 1.5, math.Inf(1)},
+			},
+			Want: `foo_bucket{le="0.5"} 1
+foo_bucket{le="1.5"} 3
+foo_bucket{le="+Inf"} 6
+`,
+		},
+	}
+	for _, test := range tests {
+		var buf bytes.Buffer
+		writeOpenMetricsHistogram(&buf, "foo", test.In)
+		if got := buf.String(); got != test.Want {
+			t.Fatalf("got=\n%s\nwant=\n%s", got, test.Want)
+		}
+	}
+}
+
+func Test_openMetricsName(t *testing.T) {
+	tests := []struct {
+		In   string
+		Want string
+	}{
+		{"/gc/heap/allocs:bytes", "go_gc_heap_allocs_bytes"},
+		{"/cpu/classes/gc/mark/assist:cpu-seconds", "go_cpu_classes_gc_mark_assist_cpu_seconds"},
+		{"/godebug/non-default-behavior/http2client:events", "go_godebug_non_default_behavior_http2client_events"},
+	}
+	for _, test := range tests {
+		if got := openMetricsName(test.In); got != test.Want {
+			t.Fatalf("got=%q want=%q", got, test.Want)
+		}
+	}
+}
@@ -0,0 +1,72 @@
+package main
+
+import (
+	"testing"
+)
+
+func Test_postsEncoders(t *testing.T) {
+	tests := []struct {
+		Posts []*Post
+		Want  string
+	}{
+		{nil, "null\n"},
+		{[]*Post{}, "[]\n"},
+		{[]*Post{nil}, "[null]\n"},
+		{
+			[]*Post{
+				{ID: 1, UserID: 2, Title: "Post 1", Body: "Lorem ipsum"},
+				{ID: -3, Title: `"quoted" \\ <b>&</b>`, Body: "tab\tnewline\n\x00\x1f \u2028\u2029 \u00fc"},
+			},
+			`[{"ID":1,"UserID":2,"Title":"Post 1","Body":"Lorem ipsum"},` +
+				`{"ID":-3,"UserID":0,"Title":"\"quoted\" \\\\ \u003cb\u003e\u0026\u003c/b\u003e","Body":"tab\tnewline\n\u0000\u001f \u2028\u2029 ` + "\u00fc" + `"}]` + "\n",
+		},
+	}
+	for name, enc := range postsEncoders {
+		for _, test := range tests {
+			got, err := enc([]byte("garbage")[:0], test.Posts)
+			if err != nil {
+				t.Fatalf("%s: %s", name, err)
+			} else if string(got) != test.Want {
+				t.Fatalf("%s: got=%s want=%s", name, got, test.Want)
+			}
+		}
+	}
+
+	// encoding/json writes invalid UTF-8 differently depending on the Go
+	// version, so it's only checked for the encoders not built on it.
+	for _, name := range []string{"zero-alloc", "reflect"} {
+		got, err := postsEncoders[name](nil, []*Post{{Body: "\xff"}})
+		want := `[{"ID":0,"UserID":0,"Title":"","Body":"\ufffd"}]` + "\n"
+		if err != nil {
+			t.Fatalf("%s: %s", name, err)
+		} else if string(got) != want {
+			t.Fatalf("%s: got=%s want=%s", name, got, want)
+		}
+	}
+}
+
+func Test_postsEncoders_allocs(t *testing.T) {
+	posts := []*Post{{ID: 1, UserID: 1, Title: "Post 1", Body: "Lorem ipsum"}}
+	allocs := map[string]float64{}
+	for name, enc := range postsEncoders {
+		buf := make([]byte, 0, 1024)
+		allocs[name] = testing.AllocsPerRun(100, func() {
+			if _, err := enc(buf[:0], posts); err != nil {
+				t.Fatal(err)
+			}
+		})
+	}
+	if got := allocs["zero-alloc"]; got != 0 {
+		t.Fatalf("got=%v want=%v", got, 0)
+	} else if allocs["pooled"] >= allocs["current"] || allocs["current"] >= allocs["reflect"] {
+		t.Fatalf("unexpected allocs: %v", allocs)
+	}
+}
+
+func Test_lookupPostsEncoder(t *testing.T) {
+	if _, err := lookupPostsEncoder(""); err != nil {
+		t.Fatal(err)
+	} else if _, err := lookupPostsEncoder("foo"); err == nil {
+		t.Fatalf("expected error for unknown encoder")
+	}
+}
@@ -1,11 +1,10 @@
 package main
 
 import (
-	"bytes"
 	"context"
-	"database/sql"
-	"encoding/json"
+	"fmt"
 	"net/http"
+	"runtime/trace"
 	"sync"
 	"time"
 )
@@ -54,11 +53,19 @@ long cpuHog() {
 import "C"
 
 type PostsHandler struct {
-	DB          *sql.DB
+	DB          Store
 	CPUDuration time.Duration
 	SQLDuration time.Duration
 	// CGO determines if cgo is used for simulating the CPUDuration.
 	CGO bool
+	// Encoder is the name of the postsEncoder used to encode the posts, see
+	// postsEncoders. It can be overridden per request with the encoder query
+	// parameter.
+	Encoder string
+
+	// mu protects CPUDuration, SQLDuration, CGO and Encoder which can be
+	// changed via SetParams while the handler is serving requests.
+	mu sync.RWMutex
 }
 
 func (h *PostsHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
@@ -67,13 +74,23 @@ func (h *PostsHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	posts, err := h.ioWork(r.Context(), userID)
+	enc, err := h.encoder(r.URL.Query().Get("encoder"))
+	if err != nil {
+		respondErr(w, http.StatusBadRequest, "%s\n", err)
+		return
+	}
+
+	ctx, end := startPhase(r.Context(), "ioWork")
+	posts, err := h.ioWork(ctx, userID)
+	end(err)
 	if err != nil {
 		respondErr(w, http.StatusInternalServerError, "ioWork: %s", err)
 		return
 	}
 
-	data, err := h.cpuWork(posts)
+	ctx, end = startPhase(r.Context(), "cpuWork")
+	data, err := h.cpuWork(ctx, posts, enc)
+	end(err)
 	if err != nil {
 		respondErr(w, http.StatusInternalServerError, "cpuWork: %s", err)
 		return
@@ -82,45 +99,96 @@ func (h *PostsHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 }
 
 func (h *PostsHandler) ioWork(ctx context.Context, userID int) ([]*Post, error) {
-	q := `SELECT id, user_id, title, body FROM posts, pg_sleep($1) WHERE user_id = $2`
-	var rows *sql.Rows
-	rows, err := h.DB.QueryContext(ctx, q, h.SQLDuration.Seconds(), userID)
-	if err != nil {
-		return nil, err
-	}
-	defer rows.Close()
+	h.mu.RLock()
+	sqlDuration := h.SQLDuration
+	h.mu.RUnlock()
 
-	var posts []*Post
-	for rows.Next() {
-		var p Post
-		if err = rows.Scan(&p.ID, &p.UserID, &p.Title, &p.Body); err != nil {
-			return nil, err
-		}
-		posts = append(posts, &p)
+	return h.DB.Posts(ctx, userID, sqlDuration)
+}
+
+// encoder returns the postsEncoder with the given name, or the one of the
+// handler if name is empty.
+func (h *PostsHandler) encoder(name string) (postsEncoder, error) {
+	if name == "" {
+		h.mu.RLock()
+		name = h.Encoder
+		h.mu.RUnlock()
 	}
-	return posts, nil
+	return lookupPostsEncoder(name)
 }
 
-func (h *PostsHandler) cpuWork(posts []*Post) ([]byte, error) {
+// cpuWork burns CPU for CPUDuration on a goroutine that runs in a "cpuWork"
+// region of the trace task in ctx.
+func (h *PostsHandler) cpuWork(ctx context.Context, posts []*Post, enc postsEncoder) ([]byte, error) {
+	h.mu.RLock()
+	cpuDuration, cgo := h.CPUDuration, h.CGO
+	h.mu.RUnlock()
+
 	var (
 		wg   sync.WaitGroup
 		stop = make(chan struct{})
 		data []byte
 	)
 	wg.Add(1)
-	if h.CGO {
-		go cgoCPUHog(posts, &data, &wg, stop)
-	} else {
-		go goCPUHog(posts, &data, &wg, stop)
+	hog := goCPUHog
+	if cgo {
+		hog = cgoCPUHog
 	}
-	time.Sleep(h.CPUDuration)
+	go trace.WithRegion(ctx, "cpuWork", func() { hog(posts, enc, &data, &wg, stop) })
+	time.Sleep(cpuDuration)
 	close(stop)
 	wg.Wait()
 	return data, nil
 }
 
+// Params implements TunableHandler.
+func (h *PostsHandler) Params() HandlerParams {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+
+	cpuDuration, sqlDuration, cgo, encoder := Duration(h.CPUDuration), Duration(h.SQLDuration), h.CGO, h.Encoder
+	if encoder == "" {
+		encoder = defaultPostsEncoder
+	}
+	return HandlerParams{
+		CPUDuration: &cpuDuration,
+		SQLDuration: &sqlDuration,
+		CGO:         &cgo,
+		Encoder:     &encoder,
+	}
+}
+
+// SetParams implements TunableHandler.
+func (h *PostsHandler) SetParams(p HandlerParams) error {
+	if p.PowDifficulty != nil {
+		return fmt.Errorf("powDifficulty is not supported by posts handlers")
+	} else if (p.CPUDuration != nil && *p.CPUDuration < 0) || (p.SQLDuration != nil && *p.SQLDuration < 0) {
+		return fmt.Errorf("durations must not be negative")
+	} else if p.Encoder != nil {
+		if _, err := lookupPostsEncoder(*p.Encoder); err != nil {
+			return err
+		}
+	}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if p.CPUDuration != nil {
+		h.CPUDuration = time.Duration(*p.CPUDuration)
+	}
+	if p.SQLDuration != nil {
+		h.SQLDuration = time.Duration(*p.SQLDuration)
+	}
+	if p.CGO != nil {
+		h.CGO = *p.CGO
+	}
+	if p.Encoder != nil {
+		h.Encoder = *p.Encoder
+	}
+	return nil
+}
+
 //go:noinline
-func cgoCPUHog(posts []*Post, data *[]byte, wg *sync.WaitGroup, stop chan struct{}) {
+func cgoCPUHog(posts []*Post, enc postsEncoder, data *[]byte, wg *sync.WaitGroup, stop chan struct{}) {
 	defer wg.Done()
 
 	// Call malloc through C.malloc because this is a special case that has
@@ -136,28 +204,28 @@ loop:
 			C.cpuHog()
 		}
 	}
-	var buf bytes.Buffer
-	if err := json.NewEncoder(&buf).Encode(posts); err != nil {
+	buf, err := enc(nil, posts)
+	if err != nil {
 		return
 	}
-	*data = buf.Bytes()
+	*data = buf
 }
 
 //go:noinline
-func goCPUHog(posts []*Post, data *[]byte, wg *sync.WaitGroup, stop chan struct{}) {
+func goCPUHog(posts []*Post, enc postsEncoder, data *[]byte, wg *sync.WaitGroup, stop chan struct{}) {
 	defer wg.Done()
 
+	var buf []byte
 	for {
-		var buf bytes.Buffer
-		if err := json.NewEncoder(&buf).Encode(posts); err != nil {
+		var err error
+		if buf, err = enc(buf[:0], posts); err != nil {
 			return
 		}
 		select {
 		case <-stop:
-			*data = buf.Bytes()
+			*data = buf
 			return
 		default:
-			buf.Reset()
 		}
 	}
 }
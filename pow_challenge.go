@@ -0,0 +1,122 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"sync"
+	"time"
+)
+
+const (
+	// powChallengeTTL is how long a client has to solve a challenge.
+	powChallengeTTL = time.Minute
+	// maxPowChallenges limits the number of outstanding challenges per
+	// transaction endpoint.
+	maxPowChallenges = 10000
+)
+
+var errTooManyChallenges = errors.New("too many outstanding challenges")
+
+// powChallenges keeps track of the challenges issued to clients. A challenge
+// can only be redeemed once, which protects against replaying a solved
+// challenge. The zero value is ready to use.
+type powChallenges struct {
+	mu sync.Mutex
+	m  map[string]powChallenge
+}
+
+// powChallenge is a challenge issued to a client. The client has to find a
+// nonce for which hash(powInput(Salt, data), nonce) starts with Difficulty
+// zeros.
+type powChallenge struct {
+	Salt       string    `json:"salt"`
+	Difficulty int       `json:"difficulty"`
+	Expires    time.Time `json:"expires"`
+	userID     int
+}
+
+// Issue returns a new challenge for the given user.
+func (c *powChallenges) Issue(userID, difficulty int) (powChallenge, error) {
+	salt := make([]byte, 16)
+	if _, err := rand.Read(salt); err != nil {
+		return powChallenge{}, err
+	}
+	now := time.Now()
+	ch := powChallenge{
+		Salt:       hex.EncodeToString(salt),
+		Difficulty: difficulty,
+		Expires:    now.Add(powChallengeTTL),
+		userID:     userID,
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.m == nil {
+		c.m = map[string]powChallenge{}
+	}
+	if len(c.m) >= maxPowChallenges {
+		for salt, other := range c.m {
+			if now.After(other.Expires) {
+				delete(c.m, salt)
+			}
+		}
+		if len(c.m) >= maxPowChallenges {
+			return powChallenge{}, errTooManyChallenges
+		}
+	}
+	c.m[ch.Salt] = ch
+	return ch, nil
+}
+
+// Redeem removes the challenge with the given salt and returns it. It returns
+// false if there is no such challenge for the user, e.g. because it was
+// already redeemed, or if it expired.
+func (c *powChallenges) Redeem(salt string, userID int) (powChallenge, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	ch, ok := c.m[salt]
+	if !ok || ch.userID != userID {
+		return powChallenge{}, false
+	}
+	delete(c.m, salt)
+	return ch, time.Now().Before(ch.Expires)
+}
+
+// powInput returns the data a client has to find a nonce for to solve a
+// challenge.
+func powInput(salt, data string) string {
+	return salt + ":" + data
+}
+
+// PowChallengeHandler issues proof of work challenges for the transactions
+// of Transactions as JSON, e.g.:
+//
+//	{"salt":"...","difficulty":4,"expires":"2021-10-01T12:00:00Z"}
+//
+// A solved challenge is passed to the transaction handler with the salt and
+// nonce query parameters, see TransactionHandler.
+type PowChallengeHandler struct {
+	DB           Store
+	Transactions *TransactionHandler
+}
+
+func (h PowChallengeHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	userID, ok := auth(h.DB, w, r)
+	if !ok {
+		return
+	}
+
+	ch, err := h.Transactions.challenges.Issue(userID, h.Transactions.difficulty())
+	if err == errTooManyChallenges {
+		respondErr(w, http.StatusServiceUnavailable, "%s\n", err)
+		return
+	} else if err != nil {
+		respondErr(w, http.StatusInternalServerError, "issue challenge: %s\n", err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(ch)
+}
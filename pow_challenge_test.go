@@ -0,0 +1,111 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func Test_powChallenges(t *testing.T) {
+	var c powChallenges
+	ch, err := c.Issue(1, 3)
+	if err != nil {
+		t.Fatal(err)
+	} else if len(ch.Salt) != 32 || ch.Difficulty != 3 {
+		t.Fatalf("unexpected challenge: %+v", ch)
+	}
+	if _, ok := c.Redeem(ch.Salt, 2); ok {
+		t.Fatalf("redeemed challenge of another user")
+	} else if got, ok := c.Redeem(ch.Salt, 1); !ok || got.Salt != ch.Salt {
+		t.Fatalf("got=%v want=%v", ok, true)
+	} else if _, ok := c.Redeem(ch.Salt, 1); ok {
+		t.Fatalf("redeemed challenge twice")
+	}
+
+	ch, err = c.Issue(1, 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c.m[ch.Salt] = powChallenge{Salt: ch.Salt, Expires: time.Now().Add(-time.Second), userID: 1}
+	if _, ok := c.Redeem(ch.Salt, 1); ok {
+		t.Fatalf("redeemed expired challenge")
+	}
+}
+
+func Test_powClient_badDifficulty(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"salt":"foo","difficulty":41}`))
+	}))
+	defer server.Close()
+
+	c := &powClient{BaseURL: server.URL, APIKey: seedAPIKey, Path: "/transaction", Client: server.Client()}
+	report := newLoadReport()
+	if err := c.Transaction(context.Background(), "foo", report); err == nil {
+		t.Fatal("expected error")
+	} else if got := len(report.errors["challenge"]); got != 1 {
+		t.Fatalf("got=%d want=%d", got, 1)
+	}
+	if verifyDifficulty(hash("foo", 0), 41) {
+		t.Fatal("verified difficulty beyond the hash length")
+	}
+}
+
+func Test_TransactionHandler_clientPoW(t *testing.T) {
+	store, err := NewMemoryStore("fixed")
+	if err != nil {
+		t.Fatal(err)
+	}
+	th := &TransactionHandler{DB: store, PowDifficultiy: 2}
+	mux := http.NewServeMux()
+	mux.Handle("/transaction", th)
+	mux.Handle("/transaction/challenge", PowChallengeHandler{DB: store, Transactions: th})
+	server := httptest.NewServer(mux)
+	defer server.Close()
+
+	c := &powClient{BaseURL: server.URL, APIKey: seedAPIKey, Path: "/transaction", Client: server.Client()}
+	report := newLoadReport()
+	if err := c.Transaction(context.Background(), "foo", report); err != nil {
+		t.Fatal(err)
+	} else if got := len(report.latencies["transaction"]); got != 1 {
+		t.Fatalf("got=%d want=%d", got, 1)
+	}
+
+	ch, err := th.challenges.Issue(1, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, nonce := calculatePoW(ch.Difficulty, powInput(ch.Salt, "bar"))
+	do := func(data string, nonce string) int {
+		q := url.Values{"key": {seedAPIKey}, "data": {data}, "salt": {ch.Salt}, "nonce": {nonce}}
+		res, err := http.Get(server.URL + "/transaction?" + q.Encode())
+		if err != nil {
+			t.Fatal(err)
+		}
+		res.Body.Close()
+		return res.StatusCode
+	}
+	if got := do("bar", "x"); got != http.StatusBadRequest {
+		t.Fatalf("got=%d want=%d", got, http.StatusBadRequest)
+	} else if got := do("bar", strconv.Itoa(nonce)); got != http.StatusOK {
+		t.Fatalf("got=%d want=%d", got, http.StatusOK)
+	} else if got := do("bar", strconv.Itoa(nonce)); got != http.StatusForbidden {
+		t.Fatalf("replay: got=%d want=%d", got, http.StatusForbidden)
+	}
+
+	// A nonce solving the challenge for other data is rejected.
+	ch, err = th.challenges.Issue(1, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, nonce = calculatePoW(ch.Difficulty, powInput(ch.Salt, "bar"))
+	if verifyDifficulty(hash(powInput(ch.Salt, "baz"), nonce), ch.Difficulty) {
+		t.Skip("nonce happens to solve the challenge for both bar and baz")
+	}
+	if got := do("baz", strconv.Itoa(nonce)); got != http.StatusForbidden {
+		t.Fatalf("got=%d want=%d", got, http.StatusForbidden)
+	}
+}
@@ -0,0 +1,161 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math/rand"
+	"net/http"
+	"net/url"
+	"os"
+	"os/signal"
+	"runtime/pprof"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// runPowClient implements the pow-client subcommand which records
+// transactions by solving the proof of work challenges of a running
+// go-prof-app instance, so the work is split between client and server.
+func runPowClient(args []string) error {
+	var (
+		fs           = flag.NewFlagSet("pow-client", flag.ExitOnError)
+		addrF        = fs.String("addr", "http://localhost:8080", "Base url of the go-prof-app to send transactions to")
+		keyF         = fs.String("key", seedAPIKey, "API key to use for requests")
+		pathF        = fs.String("path", "/transaction", "Path of the transaction endpoint, challenges are requested from <path>/challenge")
+		concurrencyF = fs.Int("concurrency", 1, "Number of transactions to solve concurrently")
+		durationF    = fs.Duration("duration", 30*time.Second, "How long to send transactions for")
+		cpuProfileF  = fs.String("cpuprofile", "", "Write a CPU profile of the client to this file")
+	)
+	fs.Parse(args)
+
+	if *cpuProfileF != "" {
+		f, err := os.Create(*cpuProfileF)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		if err := pprof.StartCPUProfile(f); err != nil {
+			return err
+		}
+		defer pprof.StopCPUProfile()
+	}
+
+	ctx, cancel := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer cancel()
+	ctx, cancel = context.WithTimeout(ctx, *durationF)
+	defer cancel()
+
+	fmt.Printf("Sending transactions to %s%s for %s with concurrency %d\n", *addrF, *pathF, *durationF, *concurrencyF)
+	c := &powClient{
+		BaseURL: *addrF,
+		APIKey:  *keyF,
+		Path:    *pathF,
+		Client:  &http.Client{Timeout: 30 * time.Second},
+	}
+	report := c.Run(ctx, *concurrencyF)
+	report.Print(os.Stdout)
+	return nil
+}
+
+// powClient records transactions with client side proof of work.
+type powClient struct {
+	BaseURL string
+	APIKey  string
+	Path    string
+	Client  *http.Client
+}
+
+// Run records transactions from concurrency goroutines until ctx is done. The
+// report has the latencies of the challenge and transaction requests, as well
+// as the time spent on solving the challenges.
+func (c *powClient) Run(ctx context.Context, concurrency int) *loadReport {
+	report := newLoadReport()
+	var wg sync.WaitGroup
+	for i := 0; i < concurrency; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for ctx.Err() == nil {
+				if err := c.Transaction(ctx, strconv.FormatInt(rand.Int63(), 36), report); err != nil {
+					// Don't spin if the server is down.
+					select {
+					case <-time.After(100 * time.Millisecond):
+					case <-ctx.Done():
+					}
+				}
+			}
+		}()
+	}
+	wg.Wait()
+	return report
+}
+
+// Transaction gets a challenge, solves it and records a transaction with the
+// given data. The steps are recorded in report, an error is returned if any
+// of them failed.
+func (c *powClient) Transaction(ctx context.Context, data string, report *loadReport) error {
+	start := time.Now()
+	body, err := c.get(ctx, strings.TrimSuffix(c.Path, "/")+"/challenge", nil)
+	var ch powChallenge
+	if err == nil {
+		err = json.Unmarshal(body, &ch)
+	}
+	if err == nil {
+		// Don't let a bad server make the client solve forever.
+		err = validatePowDifficulty(ch.Difficulty)
+	}
+	if ctx.Err() != nil {
+		return ctx.Err()
+	}
+	report.Record("challenge", time.Since(start), err)
+	if err != nil {
+		return err
+	}
+
+	start = time.Now()
+	_, nonce := calculatePoW(ch.Difficulty, powInput(ch.Salt, data))
+	report.Record("solve", time.Since(start), nil)
+
+	start = time.Now()
+	_, err = c.get(ctx, c.Path, url.Values{
+		"data":  {data},
+		"salt":  {ch.Salt},
+		"nonce": {strconv.Itoa(nonce)},
+	})
+	if ctx.Err() != nil {
+		return ctx.Err()
+	}
+	report.Record("transaction", time.Since(start), err)
+	return err
+}
+
+// get sends a GET request for path with the api key and the given query
+// parameters and returns the response body.
+func (c *powClient) get(ctx context.Context, path string, q url.Values) ([]byte, error) {
+	if q == nil {
+		q = url.Values{}
+	}
+	q.Set("key", c.APIKey)
+	req, err := http.NewRequestWithContext(ctx, "GET", strings.TrimSuffix(c.BaseURL, "/")+path+"?"+q.Encode(), nil)
+	if err != nil {
+		return nil, err
+	}
+	res, err := c.Client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+	body, err := ioutil.ReadAll(io.LimitReader(res.Body, 1<<20))
+	if err != nil {
+		return nil, err
+	} else if res.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("http status %d", res.StatusCode)
+	}
+	return body, nil
+}
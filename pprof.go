@@ -0,0 +1,46 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/pprof"
+	runtimepprof "runtime/pprof"
+	"strconv"
+)
+
+// newPprofMux returns a mux serving the net/http/pprof endpoints under
+// /debug/pprof/. It's meant for a separate admin listener, so the profiling
+// endpoints are not exposed on the main listener.
+func newPprofMux() *http.ServeMux {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/debug/pprof/", pprof.Index)
+	mux.HandleFunc("/debug/pprof/cmdline", pprof.Cmdline)
+	mux.HandleFunc("/debug/pprof/profile", pprof.Profile)
+	mux.HandleFunc("/debug/pprof/symbol", pprof.Symbol)
+	mux.HandleFunc("/debug/pprof/trace", pprof.Trace)
+	return mux
+}
+
+// LabelHandler runs h with profiler labels for the endpoint, the handler
+// type and whether cgo is used. The labels are inherited by goroutines
+// started by h, so e.g. the goCPUHog samples can be split by route even if
+// multiple routes share the same handler type.
+func LabelHandler(endpoint, handlerType string, h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		cgo := false
+		// Read the cgo mode on every request, it can be changed at runtime.
+		if th, ok := h.(TunableHandler); ok {
+			if p := th.Params(); p.CGO != nil {
+				cgo = *p.CGO
+			}
+		}
+		labels := runtimepprof.Labels(
+			"endpoint", endpoint,
+			"handler", handlerType,
+			"cgo", strconv.FormatBool(cgo),
+		)
+		runtimepprof.Do(r.Context(), labels, func(ctx context.Context) {
+			h.ServeHTTP(w, r.WithContext(ctx))
+		})
+	})
+}
@@ -0,0 +1,52 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"runtime/pprof"
+	"testing"
+)
+
+func Test_LabelHandler(t *testing.T) {
+	plain := &labelRecorder{}
+	tunable := &tunableLabelRecorder{cgo: true}
+	tests := []struct {
+		Handler  http.Handler
+		Recorder *labelRecorder
+		Want     map[string]string
+	}{
+		{plain, plain, map[string]string{"endpoint": "/foo", "handler": "bar", "cgo": "false"}},
+		{tunable, &tunable.labelRecorder, map[string]string{"endpoint": "/foo", "handler": "bar", "cgo": "true"}},
+	}
+	for _, test := range tests {
+		req := httptest.NewRequest("GET", "/foo", nil)
+		LabelHandler("/foo", "bar", test.Handler).ServeHTTP(httptest.NewRecorder(), req)
+		if !reflect.DeepEqual(test.Recorder.labels, test.Want) {
+			t.Fatalf("got=%v want=%v", test.Recorder.labels, test.Want)
+		}
+	}
+}
+
+// labelRecorder records the profiler labels of the last request.
+type labelRecorder struct {
+	labels map[string]string
+}
+
+func (h *labelRecorder) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	h.labels = map[string]string{}
+	pprof.ForLabels(r.Context(), func(key, value string) bool {
+		h.labels[key] = value
+		return true
+	})
+}
+
+// tunableLabelRecorder is a labelRecorder implementing TunableHandler.
+type tunableLabelRecorder struct {
+	labelRecorder
+	cgo bool
+}
+
+func (h *tunableLabelRecorder) Params() HandlerParams { return HandlerParams{CGO: &h.cgo} }
+
+func (h *tunableLabelRecorder) SetParams(HandlerParams) error { return nil }
@@ -0,0 +1,312 @@
+package main
+
+import (
+	"bytes"
+	_ "embed"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+//go:embed scenarios/default.json
+var defaultScenarioJSON []byte
+
+// Scenario describes the endpoints served by the app and the load that should
+// be sent to them. Scenarios are loaded from JSON files, see the scenarios
+// directory for examples.
+type Scenario struct {
+	Endpoints []ScenarioEndpoint `json:"endpoints"`
+	Load      []ScenarioPhase    `json:"load"`
+}
+
+// ScenarioEndpoint configures a single route. Which of the fields are used
+// depends on the handler.
+type ScenarioEndpoint struct {
+	Path    string   `json:"path"`
+	Methods []string `json:"methods"` // default: GET
+	// Handler is one of: posts, transaction, memory-leak, lock-contention,
+	// goroutine-leak, off-cpu, gc-pressure.
+	Handler string `json:"handler"`
+
+	// posts, memory-leak
+	CPUDuration Duration `json:"cpuDuration"`
+	SQLDuration Duration `json:"sqlDuration"`
+	CGO         bool     `json:"cgo"`
+	// posts, see postsEncoders
+	Encoder string `json:"encoder"`
+	// transaction, defaults to the -powDifficulty flag
+	PowDifficulty int `json:"powDifficulty"`
+	// lock-contention
+	Goroutines      int      `json:"goroutines"`
+	Iterations      int      `json:"iterations"`
+	CriticalSection Duration `json:"criticalSection"`
+	// off-cpu
+	Sleep       Duration `json:"sleep"`
+	ChanRecv    Duration `json:"chanRecv"`
+	Select      Duration `json:"select"`
+	PipeRead    Duration `json:"pipeRead"`
+	Upstream    Duration `json:"upstream"`
+	UpstreamURL string   `json:"upstreamURL"`
+	// gc-pressure
+	AllocRate      ByteSize `json:"allocRate"`
+	AllocDuration  Duration `json:"allocDuration"`
+	Lifetime       Duration `json:"lifetime"`
+	ObjectSize     ByteSize `json:"objectSize"`
+	PointerDensity float64  `json:"pointerDensity"`
+}
+
+// ScenarioPhase is a period of constant load sent by the load subcommand.
+type ScenarioPhase struct {
+	RPS      float64  `json:"rps"`
+	Duration Duration `json:"duration"`
+	// Mix maps endpoint paths to their relative weight.
+	Mix map[string]float64 `json:"mix"`
+}
+
+// Duration is a time.Duration that is encoded as a string like "10ms" in
+// JSON.
+type Duration time.Duration
+
+func (d *Duration) UnmarshalJSON(data []byte) error {
+	var s string
+	if err := json.Unmarshal(data, &s); err != nil {
+		return fmt.Errorf("duration must be a string like \"10ms\": %s", data)
+	}
+	dd, err := time.ParseDuration(s)
+	if err != nil {
+		return err
+	}
+	*d = Duration(dd)
+	return nil
+}
+
+func (d Duration) MarshalJSON() ([]byte, error) {
+	return json.Marshal(time.Duration(d).String())
+}
+
+// ByteSize is a number of bytes that is encoded as a string like "64MiB" in
+// JSON, see parseByteSize.
+type ByteSize int64
+
+func (b *ByteSize) UnmarshalJSON(data []byte) error {
+	var s string
+	if err := json.Unmarshal(data, &s); err != nil {
+		return fmt.Errorf("byte size must be a string like \"64MiB\": %s", data)
+	}
+	n, err := parseByteSize(s)
+	if err != nil {
+		return err
+	}
+	*b = ByteSize(n)
+	return nil
+}
+
+// byteSizeUnits are the suffixes understood by parseByteSize, the same as for
+// GOMEMLIMIT.
+var byteSizeUnits = []struct {
+	Suffix string
+	Size   int64
+}{
+	{"KiB", 1 << 10},
+	{"MiB", 1 << 20},
+	{"GiB", 1 << 30},
+	{"TiB", 1 << 40},
+	{"B", 1},
+}
+
+// parseByteSize parses a non-negative number of bytes with an optional unit
+// suffix, e.g. 512, 64KiB or 1.5GiB.
+func parseByteSize(s string) (int64, error) {
+	num, unit := s, int64(1)
+	for _, u := range byteSizeUnits {
+		if strings.HasSuffix(s, u.Suffix) {
+			num, unit = strings.TrimSuffix(s, u.Suffix), u.Size
+			break
+		}
+	}
+	f, err := strconv.ParseFloat(num, 64)
+	if err != nil || f < 0 || math.IsInf(f, 0) {
+		return 0, fmt.Errorf("bad byte size: %q", s)
+	}
+	n := f * float64(unit)
+	// float64(math.MaxInt64) rounds up to 1<<63, which overflows int64.
+	if n >= float64(math.MaxInt64) {
+		return 0, fmt.Errorf("byte size overflows int64: %q", s)
+	}
+	return int64(n), nil
+}
+
+// LoadScenario reads the scenario from the given JSON file. If path is empty,
+// the built-in default scenario is returned.
+func LoadScenario(path string) (*Scenario, error) {
+	data := defaultScenarioJSON
+	if path != "" {
+		var err error
+		if data, err = ioutil.ReadFile(path); err != nil {
+			return nil, err
+		}
+	}
+	return parseScenario(data)
+}
+
+func parseScenario(data []byte) (*Scenario, error) {
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.DisallowUnknownFields()
+	var s Scenario
+	if err := dec.Decode(&s); err != nil {
+		return nil, fmt.Errorf("parseScenario: %w", err)
+	}
+	for i, e := range s.Endpoints {
+		if e.Path == "" || e.Path[0] != '/' {
+			return nil, fmt.Errorf("parseScenario: endpoint %d: path must start with /: %q", i, e.Path)
+		}
+		if len(e.Methods) == 0 {
+			s.Endpoints[i].Methods = []string{"GET"}
+		}
+	}
+	return &s, nil
+}
+
+// scenarioRoute is a handler to be registered for the given method and path.
+type scenarioRoute struct {
+	Method string
+	Path   string
+	// Name is the handler name used in the scenario, e.g. posts.
+	Name    string
+	Handler http.Handler
+}
+
+// appRoutes are the routes registered by the app itself besides the ones under
+// /admin/, see run.
+var appRoutes = []string{"GET /", "GET /metrics"}
+
+// Routes returns the routes for all endpoints of the scenario. Handlers
+// registered for multiple methods are shared between them. Transaction
+// endpoints get an additional GET <path>/challenge route serving their proof
+// of work challenges, see PowChallengeHandler.
+//
+// Routes returns an error if a route is defined twice or collides with one of
+// the app's own routes, which would make the router panic.
+func (s *Scenario) Routes(db Store, powDifficulty int) ([]scenarioRoute, error) {
+	var routes []scenarioRoute
+	seen := map[string]bool{}
+	for _, route := range appRoutes {
+		seen[route] = true
+	}
+	add := func(r scenarioRoute) error {
+		key := r.Method + " " + r.Path
+		if strings.HasPrefix(r.Path, "/admin/") {
+			return fmt.Errorf("%s: path is reserved for admin routes", key)
+		} else if seen[key] {
+			return fmt.Errorf("%s: route is already defined", key)
+		}
+		seen[key] = true
+		routes = append(routes, r)
+		return nil
+	}
+	for _, e := range s.Endpoints {
+		h, err := e.newHandler(db, powDifficulty)
+		if err != nil {
+			return nil, err
+		}
+		for _, method := range e.Methods {
+			if err := add(scenarioRoute{Method: method, Path: e.Path, Name: e.Handler, Handler: h}); err != nil {
+				return nil, err
+			}
+		}
+		if th, ok := h.(*TransactionHandler); ok {
+			if err := add(scenarioRoute{
+				Method:  "GET",
+				Path:    strings.TrimSuffix(e.Path, "/") + "/challenge",
+				Name:    "pow-challenge",
+				Handler: PowChallengeHandler{DB: db, Transactions: th},
+			}); err != nil {
+				return nil, fmt.Errorf("%s: challenge: %w", e.Path, err)
+			}
+		}
+	}
+	return routes, nil
+}
+
+func (e ScenarioEndpoint) newHandler(db Store, powDifficulty int) (http.Handler, error) {
+	switch e.Handler {
+	case "posts":
+		if _, err := lookupPostsEncoder(e.Encoder); err != nil {
+			return nil, fmt.Errorf("%s: %w", e.Path, err)
+		}
+		return &PostsHandler{
+			DB:          db,
+			CPUDuration: time.Duration(e.CPUDuration),
+			SQLDuration: time.Duration(e.SQLDuration),
+			CGO:         e.CGO,
+			Encoder:     e.Encoder,
+		}, nil
+	case "transaction":
+		if e.PowDifficulty != 0 {
+			powDifficulty = e.PowDifficulty
+		}
+		if err := validatePowDifficulty(powDifficulty); err != nil {
+			return nil, fmt.Errorf("%s: %w", e.Path, err)
+		}
+		return &TransactionHandler{DB: db, PowDifficultiy: powDifficulty}, nil
+	case "memory-leak":
+		return MemoryLeakHandler{DB: db, SQLDuration: time.Duration(e.SQLDuration)}, nil
+	case "lock-contention":
+		return LockContentionHandler{
+			DB:              db,
+			Goroutines:      e.Goroutines,
+			Iterations:      e.Iterations,
+			CriticalSection: time.Duration(e.CriticalSection),
+		}, nil
+	case "goroutine-leak":
+		return GoroutineLeakHandler{DB: db}, nil
+	case "off-cpu":
+		return OffCPUHandler{
+			DB:          db,
+			Sleep:       time.Duration(e.Sleep),
+			ChanRecv:    time.Duration(e.ChanRecv),
+			Select:      time.Duration(e.Select),
+			PipeRead:    time.Duration(e.PipeRead),
+			Upstream:    time.Duration(e.Upstream),
+			UpstreamURL: e.UpstreamURL,
+		}, nil
+	case "gc-pressure":
+		return GCPressureHandler{
+			DB:             db,
+			Rate:           int64(e.AllocRate),
+			Duration:       time.Duration(e.AllocDuration),
+			Lifetime:       time.Duration(e.Lifetime),
+			ObjectSize:     int64(e.ObjectSize),
+			PointerDensity: e.PointerDensity,
+		}, nil
+	default:
+		return nil, fmt.Errorf("%s: unknown handler: %q", e.Path, e.Handler)
+	}
+}
+
+// LoadPhases converts the load schedule of the scenario for use with
+// loadGenerator.
+func (s *Scenario) LoadPhases() ([]loadPhase, error) {
+	var phases []loadPhase
+	for i, p := range s.Load {
+		phase := loadPhase{RPS: p.RPS, Duration: time.Duration(p.Duration)}
+		if err := validateLoadRPS(phase.RPS); err != nil {
+			return nil, fmt.Errorf("load phase %d: %w", i, err)
+		}
+		for endpoint, weight := range p.Mix {
+			phase.Mix = append(phase.Mix, loadTarget{Endpoint: endpoint, Weight: weight})
+		}
+		if err := validateLoadMix(phase.Mix); err != nil {
+			return nil, fmt.Errorf("load phase %d: %w", i, err)
+		}
+		sort.Slice(phase.Mix, func(i, j int) bool { return phase.Mix[i].Endpoint < phase.Mix[j].Endpoint })
+		phases = append(phases, phase)
+	}
+	return phases, nil
+}
@@ -0,0 +1,120 @@
+package main
+
+import (
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func Test_LoadScenario(t *testing.T) {
+	store, err := NewMemoryStore("fixed")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	paths, err := filepath.Glob("scenarios/*.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, path := range append(paths, "") {
+		s, err := LoadScenario(path)
+		if err != nil {
+			t.Fatalf("%q: %s", path, err)
+		}
+		if _, err := s.Routes(store, 4); err != nil {
+			t.Fatalf("%q: %s", path, err)
+		}
+		if _, err := s.LoadPhases(); err != nil {
+			t.Fatalf("%q: %s", path, err)
+		}
+	}
+}
+
+func Test_Scenario_Routes(t *testing.T) {
+	s, err := parseScenario([]byte(`{
+		"endpoints": [
+			{"path": "/cgo", "handler": "posts", "cpuDuration": "90ms", "sqlDuration": "10ms", "cgo": true},
+			{"path": "/tx", "methods": ["GET", "POST"], "handler": "transaction"},
+			{"path": "/tx-hard", "handler": "transaction", "powDifficulty": 6}
+		]
+	}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	routes, err := s.Routes(nil, 4)
+	if err != nil {
+		t.Fatal(err)
+	} else if len(routes) != 6 {
+		t.Fatalf("got=%d want=%d", len(routes), 6)
+	}
+
+	ph, ok := routes[0].Handler.(*PostsHandler)
+	if !ok {
+		t.Fatalf("got=%T want=%T", routes[0].Handler, ph)
+	} else if ph.CPUDuration != 90*time.Millisecond || ph.SQLDuration != 10*time.Millisecond || !ph.CGO {
+		t.Fatalf("unexpected handler: %+v", ph)
+	}
+	if routes[1].Method != "GET" || routes[2].Method != "POST" || routes[1].Handler != routes[2].Handler {
+		t.Fatalf("unexpected routes: %+v", routes[1:3])
+	}
+	if got := routes[1].Handler.(*TransactionHandler).PowDifficultiy; got != 4 {
+		t.Fatalf("got=%d want=%d", got, 4)
+	}
+	if ch, ok := routes[3].Handler.(PowChallengeHandler); !ok || routes[3].Path != "/tx/challenge" || ch.Transactions != routes[1].Handler {
+		t.Fatalf("unexpected challenge route: %+v", routes[3])
+	}
+	if got := routes[4].Handler.(*TransactionHandler).PowDifficultiy; got != 6 {
+		t.Fatalf("got=%d want=%d", got, 6)
+	}
+}
+
+func Test_parseScenario_errors(t *testing.T) {
+	tests := []string{
+		`{"endpoints": [{"path": "/foo", "handler": "posts", "cpuDuration": 10}]}`,
+		`{"endpoints": [{"path": "/foo", "handler": "posts", "cpuDurtion": "10ms"}]}`,
+		`{"endpoints": [{"path": "foo", "handler": "posts"}]}`,
+	}
+	for _, test := range tests {
+		if _, err := parseScenario([]byte(test)); err == nil {
+			t.Fatalf("expected error for %s", test)
+		}
+	}
+
+	s, err := parseScenario([]byte(`{"endpoints": [{"path": "/foo", "handler": "foo"}]}`))
+	if err != nil {
+		t.Fatal(err)
+	} else if _, err := s.Routes(nil, 4); err == nil {
+		t.Fatalf("expected error for unknown handler")
+	}
+	for _, test := range []string{
+		`{"endpoints": [{"path": "/foo", "handler": "posts"}, {"path": "/foo", "methods": ["POST", "GET"], "handler": "posts"}]}`,
+		`{"endpoints": [{"path": "/foo", "methods": ["GET", "GET"], "handler": "posts"}]}`,
+		`{"endpoints": [{"path": "/metrics", "handler": "posts"}]}`,
+		`{"endpoints": [{"path": "/admin/handlers", "handler": "posts"}]}`,
+		`{"endpoints": [{"path": "/tx", "handler": "transaction", "powDifficulty": 7}]}`,
+		`{"endpoints": [{"path": "/tx", "handler": "transaction"}, {"path": "/tx/challenge", "handler": "posts"}]}`,
+		`{"endpoints": [{"path": "/challenge", "handler": "posts"}, {"path": "/", "methods": ["POST"], "handler": "transaction"}]}`,
+	} {
+		s, err := parseScenario([]byte(test))
+		if err != nil {
+			t.Fatal(err)
+		} else if _, err := s.Routes(nil, 4); err == nil {
+			t.Fatalf("expected error for %s", test)
+		}
+	}
+
+	s, err = parseScenario([]byte(`{"endpoints": [{"path": "/admin/foo", "handler": "posts"}]}`))
+	if err != nil {
+		t.Fatal(err)
+	} else if _, err := s.Routes(nil, 4); err == nil || !strings.Contains(err.Error(), "reserved") {
+		t.Fatalf("got=%v want reserved path error", err)
+	}
+
+	s, err = parseScenario([]byte(`{"endpoints": [{"path": "/foo", "handler": "posts", "encoder": "foo"}]}`))
+	if err != nil {
+		t.Fatal(err)
+	} else if _, err := s.Routes(nil, 4); err == nil {
+		t.Fatalf("expected error for unknown encoder")
+	}
+}
@@ -0,0 +1,35 @@
+DROP TABLE IF EXISTS transactions, posts, users;
+
+CREATE TABLE users (
+  id int AUTO_INCREMENT PRIMARY KEY,
+  api_key text NOT NULL
+);
+
+CREATE TABLE transactions (
+  id int AUTO_INCREMENT PRIMARY KEY,
+  user_id int NOT NULL,
+  data text,
+  FOREIGN KEY (user_id) REFERENCES users (id)
+);
+
+CREATE TABLE posts (
+  id int AUTO_INCREMENT PRIMARY KEY,
+  user_id int NOT NULL,
+  title text,
+  body text,
+  FOREIGN KEY (user_id) REFERENCES users (id)
+);
+
+INSERT INTO users (id, api_key) VALUES (1, '9A0830DE-CB45-42B0-8155-BB61733AB5B0');
+
+INSERT INTO posts (user_id, title, body)
+WITH RECURSIVE series (post_num) AS (
+  SELECT 1
+  UNION ALL
+  SELECT post_num + 1 FROM series WHERE post_num < 100
+)
+SELECT
+  1,
+  CONCAT('Post ', post_num),
+  CONCAT('Lorem ', post_num, ' ipsum dolor sit amet, consectetur adipiscing elit. Suspendisse non urna vestibulum orci sollicitudin egestas. Sed gravida at lectus non ornare. Sed accumsan tellus nec ligula feugiat vestibulum. Nullam commodo ac odio vel euismod. Proin posuere, ipsum et fringilla viverra, nisl sem venenatis purus, at pretium nisl lorem a augue. Maecenas et justo tellus. Nunc rutrum blandit nulla, tempus dictum est bibendum vitae. Phasellus at mauris quis justo vehicula sagittis et quis ipsum. Nullam dictum tempor enim et viverra. Etiam sagittis rhoncus ex, vel rutrum turpis euismod quis. Integer tristique nulla vulputate neque tempus maximus. Mauris lacinia turpis leo.')
+FROM series;
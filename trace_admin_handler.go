@@ -0,0 +1,216 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"runtime/trace"
+	"sync"
+	"time"
+)
+
+// maxTraceDuration limits how long a single trace captured via
+// TraceAdminHandler can run, including traces that run until stopped.
+const maxTraceDuration = 10 * time.Minute
+
+var (
+	errTraceActive   = errors.New("a trace is already being captured")
+	errTraceShutdown = errors.New("shutting down")
+)
+
+// traceCapture captures runtime/trace execution traces on demand. Only one
+// trace can be captured at a time, by traceCapture or anybody else, e.g. the
+// -trace flag or /debug/pprof/trace.
+type traceCapture struct {
+	mu     sync.Mutex
+	stop   chan struct{}
+	closed bool
+	wg     sync.WaitGroup
+}
+
+// Start starts tracing to w. The trace is stopped once d elapsed, ctx is done
+// or Stop was called. A d of 0 means maxTraceDuration. finish, if not nil, is
+// called after the trace has been stopped, and the returned channel is closed
+// after that.
+func (c *traceCapture) Start(ctx context.Context, w io.Writer, d time.Duration, finish func()) (<-chan struct{}, error) {
+	if d <= 0 || d > maxTraceDuration {
+		d = maxTraceDuration
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.closed {
+		return nil, errTraceShutdown
+	} else if c.stop != nil {
+		return nil, errTraceActive
+	} else if err := trace.Start(w); err != nil {
+		return nil, err
+	}
+	stop := make(chan struct{}, 1)
+	c.stop = stop
+
+	done := make(chan struct{})
+	c.wg.Add(1)
+	go func() {
+		defer c.wg.Done()
+		defer close(done)
+
+		timer := time.NewTimer(d)
+		defer timer.Stop()
+		select {
+		case <-timer.C:
+		case <-ctx.Done():
+		case <-stop:
+		}
+
+		c.mu.Lock()
+		trace.Stop()
+		c.stop = nil
+		c.mu.Unlock()
+		if finish != nil {
+			finish()
+		}
+	}()
+	return done, nil
+}
+
+// Stop stops the active trace, if any. It returns false if there is none.
+func (c *traceCapture) Stop() bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.stop == nil {
+		return false
+	}
+	select {
+	case c.stop <- struct{}{}:
+	default:
+		// Already stopping.
+	}
+	return true
+}
+
+// Shutdown stops the active trace and waits for it to be finalized. No new
+// traces can be started afterwards.
+func (c *traceCapture) Shutdown() {
+	c.mu.Lock()
+	c.closed = true
+	c.mu.Unlock()
+	c.Stop()
+	c.wg.Wait()
+}
+
+// TraceAdminHandler captures execution traces while the app is running.
+//
+// GET streams a trace back to the client, POST saves it to a file in Dir and
+// returns the name of the file right away. Both take a seconds query
+// parameter, 0 or none means the trace runs until it is stopped, but not
+// longer than 10 minutes. DELETE stops the active trace.
+//
+// While the flight recorder is running, it holds runtime/trace and GET and
+// POST fail with 409 Conflict. Use its snapshots instead.
+//
+//	curl -o cgo.trace 'localhost:8080/admin/trace?key=...&seconds=5'
+type TraceAdminHandler struct {
+	DB      Store
+	Capture *traceCapture
+	// FlightRecorder is the flight recorder of the app, if any.
+	FlightRecorder *flightRecorder
+	// Dir is the directory traces are saved to by POST.
+	Dir string
+	// Keep is the number of saved traces to keep in Dir, older ones are
+	// removed. 0 keeps all traces.
+	Keep int
+}
+
+func (h TraceAdminHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if _, ok := auth(h.DB, w, r); !ok {
+		return
+	}
+
+	if r.Method == http.MethodDelete {
+		if !h.Capture.Stop() {
+			respondErr(w, http.StatusNotFound, "no trace is being captured\n")
+			return
+		}
+		fmt.Fprintf(w, "stopped trace\n")
+		return
+	}
+
+	seconds, err := parseIntParam(r.URL.Query().Get("seconds"))
+	if err != nil {
+		respondErr(w, http.StatusBadRequest, "bad seconds: %s\n", err)
+		return
+	}
+	d := time.Duration(seconds) * time.Second
+
+	if (r.Method == http.MethodGet || r.Method == http.MethodPost) && h.FlightRecorder != nil && h.FlightRecorder.Running() {
+		respondErr(w, http.StatusConflict, "flight recorder active: it holds the execution tracer, take a snapshot via /admin/flight-recorder instead\n")
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		h.stream(w, r, d)
+	case http.MethodPost:
+		h.save(w, d)
+	default:
+		respondErr(w, http.StatusMethodNotAllowed, "method not allowed: %s\n", r.Method)
+	}
+}
+
+func (h TraceAdminHandler) stream(w http.ResponseWriter, r *http.Request, d time.Duration) {
+	w.Header().Set("Content-Type", "application/octet-stream")
+	w.Header().Set("Content-Disposition", `attachment; filename="trace.out"`)
+	done, err := h.Capture.Start(r.Context(), w, d, nil)
+	if err != nil {
+		w.Header().Del("Content-Disposition")
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		respondErr(w, http.StatusConflict, "start trace: %s\n", err)
+		return
+	}
+	// The trace writes to w until it's stopped, so the handler must not
+	// return earlier.
+	<-done
+}
+
+func (h TraceAdminHandler) save(w http.ResponseWriter, d time.Duration) {
+	if err := os.MkdirAll(h.Dir, 0755); err != nil {
+		respondErr(w, http.StatusInternalServerError, "create trace dir: %s\n", err)
+		return
+	}
+	path := filepath.Join(h.Dir, "trace-"+time.Now().UTC().Format("20060102-150405.000000")+".out")
+	f, err := os.Create(path)
+	if err != nil {
+		respondErr(w, http.StatusInternalServerError, "create trace file: %s\n", err)
+		return
+	}
+	finish := func() {
+		if err := f.Close(); err != nil {
+			log.Printf("Failed to save trace %q: %s", path, err)
+			return
+		}
+		log.Printf("Saved trace to %q", path)
+		if err := rotateTraces(h.Dir, h.Keep); err != nil {
+			log.Printf("Failed to remove old traces: %s", err)
+		}
+	}
+	if _, err := h.Capture.Start(context.Background(), f, d, finish); err != nil {
+		f.Close()
+		os.Remove(path)
+		respondErr(w, http.StatusConflict, "start trace: %s\n", err)
+		return
+	}
+	w.WriteHeader(http.StatusAccepted)
+	fmt.Fprintf(w, "capturing trace to %s\n", path)
+}
+
+// rotateTraces removes all but the keep most recent traces saved by
+// TraceAdminHandler in dir. A keep of 0 keeps all traces.
+func rotateTraces(dir string, keep int) error {
+	return pruneFiles(filepath.Join(dir, "trace-*.out"), keep, 0)
+}
@@ -0,0 +1,74 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func Test_TraceAdminHandler(t *testing.T) {
+	store, err := NewMemoryStore("fixed")
+	if err != nil {
+		t.Fatal(err)
+	}
+	dir := t.TempDir()
+	capture := &traceCapture{}
+	defer capture.Shutdown()
+	h := TraceAdminHandler{DB: store, Capture: capture, Dir: dir, Keep: 1}
+
+	do := func(method, query string) *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, httptest.NewRequest(method, "/admin/trace?key="+seedAPIKey+query, nil))
+		return rec
+	}
+
+	rec := do("GET", "&seconds=1")
+	if rec.Code != http.StatusOK {
+		t.Fatalf("got=%d want=%d: %s", rec.Code, http.StatusOK, rec.Body)
+	} else if !strings.HasPrefix(rec.Body.String(), "go 1.") {
+		t.Fatalf("not a trace: %q", rec.Body.String()[:10])
+	}
+
+	if rec := do("DELETE", ""); rec.Code != http.StatusNotFound {
+		t.Fatalf("got=%d want=%d", rec.Code, http.StatusNotFound)
+	}
+
+	for i := 0; i < 2; i++ {
+		if rec := do("POST", ""); rec.Code != http.StatusAccepted {
+			t.Fatalf("got=%d want=%d: %s", rec.Code, http.StatusAccepted, rec.Body)
+		}
+		if rec := do("GET", "&seconds=1"); rec.Code != http.StatusConflict {
+			t.Fatalf("got=%d want=%d", rec.Code, http.StatusConflict)
+		}
+		if rec := do("DELETE", ""); rec.Code != http.StatusOK {
+			t.Fatalf("got=%d want=%d: %s", rec.Code, http.StatusOK, rec.Body)
+		}
+		// Wait for the trace to be stopped.
+		for capture.Stop() {
+			time.Sleep(time.Millisecond)
+		}
+	}
+
+	// The older trace is removed once the second one has been saved.
+	var paths []string
+	for deadline := time.Now().Add(time.Second); ; time.Sleep(time.Millisecond) {
+		paths, err = filepath.Glob(filepath.Join(dir, "trace-*.out"))
+		if err != nil {
+			t.Fatal(err)
+		} else if len(paths) == 1 {
+			break
+		} else if time.Now().After(deadline) {
+			t.Fatalf("got=%d want=%d", len(paths), 1)
+		}
+	}
+	data, err := os.ReadFile(paths[0])
+	if err != nil {
+		t.Fatal(err)
+	} else if !strings.HasPrefix(string(data), "go 1.") {
+		t.Fatalf("not a trace: %q", data[:10])
+	}
+}
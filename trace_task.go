@@ -0,0 +1,42 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"runtime/trace"
+	"strconv"
+
+	"gopkg.in/DataDog/dd-trace-go.v1/ddtrace/tracer"
+)
+
+// TaskHandler runs every request to h in a runtime/trace task named after
+// the endpoint. The IDs of the request's APM span are logged to the task, so
+// tasks in execution traces can be matched up with their APM traces.
+func TaskHandler(endpoint string, h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx, task := trace.NewTask(r.Context(), endpoint)
+		defer task.End()
+		// The IDs are 0 if the tracer is disabled.
+		if span, ok := tracer.SpanFromContext(ctx); ok && span.Context().SpanID() != 0 {
+			trace.Log(ctx, "trace_id", strconv.FormatUint(span.Context().TraceID(), 10))
+			trace.Log(ctx, "span_id", strconv.FormatUint(span.Context().SpanID(), 10))
+		}
+		h.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+// startPhase starts an APM span and a runtime/trace region with the given
+// name for a phase of a request. The span ID is logged in the region, so both
+// can be matched up. The returned func ends the region and finishes the span
+// with err. It must be called on the same goroutine as startPhase.
+func startPhase(ctx context.Context, name string) (context.Context, func(err error)) {
+	span, ctx := tracer.StartSpanFromContext(ctx, name)
+	region := trace.StartRegion(ctx, name)
+	if id := span.Context().SpanID(); id != 0 {
+		trace.Log(ctx, name+".span_id", strconv.FormatUint(id, 10))
+	}
+	return ctx, func(err error) {
+		region.End()
+		span.Finish(tracer.WithError(err))
+	}
+}
@@ -0,0 +1,30 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"runtime/trace"
+	"testing"
+)
+
+func Test_TaskHandler(t *testing.T) {
+	var buf bytes.Buffer
+	if err := trace.Start(&buf); err != nil {
+		t.Fatal(err)
+	}
+	h := TaskHandler("/test-endpoint", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, end := startPhase(r.Context(), "test-phase")
+		end(errors.New("boom"))
+	}))
+	h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/test-endpoint", nil))
+	trace.Stop()
+
+	// The task and region names end up in the trace's string table.
+	for _, want := range []string{"/test-endpoint", "test-phase"} {
+		if !bytes.Contains(buf.Bytes(), []byte(want)) {
+			t.Fatalf("trace is missing %q", want)
+		}
+	}
+}
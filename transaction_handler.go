@@ -1,50 +1,142 @@
 package main
 
 import (
+	"context"
 	"crypto/sha1"
-	"database/sql"
+	"errors"
 	"fmt"
 	"net/http"
+	"runtime/trace"
+	"strconv"
 	"sync"
-
-	"gopkg.in/DataDog/dd-trace-go.v1/ddtrace/tracer"
 )
 
+// TransactionHandler records a transaction with the given data after a proof
+// of work of PowDifficultiy.
+//
+// By default the server does the work itself. Clients can do it instead by
+// getting a challenge from PowChallengeHandler and passing its salt and the
+// nonce solving it as query parameters, e.g.:
+//
+//	GET /transaction?data=foo&salt=...&nonce=123
+//
+// Each challenge can only be used for a single transaction.
 type TransactionHandler struct {
-	DB             *sql.DB
+	DB             Store
 	PowDifficultiy int
+
+	// mu protects PowDifficultiy which can be changed via SetParams while
+	// the handler is serving requests.
+	mu sync.RWMutex
+
+	challenges powChallenges
 }
 
-func (h TransactionHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+func (h *TransactionHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	userID, ok := auth(h.DB, w, r)
 	if !ok {
 		return
 	}
 
-	powSpan, _ := tracer.StartSpanFromContext(r.Context(), "pow")
-	data := r.URL.Query().Get("data")
-	if !doPoW(data, h.PowDifficultiy) {
-		powSpan.Finish()
-		respondErr(w, http.StatusInternalServerError, "pow failure")
-		return
+	q := r.URL.Query()
+	data := q.Get("data")
+	if salt := q.Get("salt"); salt != "" {
+		_, endVerify := startPhase(r.Context(), "powVerify")
+		code, err := h.verifyClientPoW(userID, salt, data, q.Get("nonce"))
+		endVerify(err)
+		if err != nil {
+			respondErr(w, code, "%s\n", err)
+			return
+		}
+	} else {
+		ctx, endPow := startPhase(r.Context(), "pow")
+		if !doPoW(ctx, data, h.difficulty()) {
+			endPow(errPoWFailure)
+			respondErr(w, http.StatusInternalServerError, "pow failure")
+			return
+		}
+		endPow(nil)
 	}
-	powSpan.Finish()
-
-	span, ctx := tracer.StartSpanFromContext(r.Context(), "insert")
-	var txID int
-	q := `INSERT INTO transactions (user_id, data) VALUES ($1, $2) RETURNING id`
-	row := h.DB.QueryRowContext(ctx, q, userID, data)
-	if err := row.Scan(&txID); err != nil {
-		span.Finish()
+
+	ctx, endInsert := startPhase(r.Context(), "insert")
+	txID, err := h.DB.InsertTransaction(ctx, userID, data)
+	if err != nil {
+		endInsert(err)
 		respondErr(w, http.StatusInternalServerError, "insert err: %s", err)
 		return
 	}
-	span.Finish()
+	endInsert(nil)
 
 	fmt.Fprintf(w, "recorded transaction: %d\n", txID)
 }
 
-func doPoW(data string, difficulty int) bool {
+// verifyClientPoW redeems the challenge with the given salt and checks that
+// nonce solves it for data. It returns the http status code to respond with
+// if the proof of work is invalid.
+func (h *TransactionHandler) verifyClientPoW(userID int, salt, data, nonceS string) (int, error) {
+	nonce, err := strconv.Atoi(nonceS)
+	if err != nil {
+		return http.StatusBadRequest, fmt.Errorf("bad nonce: %q", nonceS)
+	}
+	ch, ok := h.challenges.Redeem(salt, userID)
+	if !ok {
+		return http.StatusForbidden, fmt.Errorf("unknown, expired or already used challenge: %q", salt)
+	}
+	input := powInput(salt, data)
+	if !verifyPoW(input, hash(input, nonce), ch.Difficulty, nonce) {
+		return http.StatusForbidden, fmt.Errorf("nonce %d does not solve challenge %q", nonce, salt)
+	}
+	return 0, nil
+}
+
+func (h *TransactionHandler) difficulty() int {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	return h.PowDifficultiy
+}
+
+// Params implements TunableHandler.
+func (h *TransactionHandler) Params() HandlerParams {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+
+	difficulty := h.PowDifficultiy
+	return HandlerParams{PowDifficulty: &difficulty}
+}
+
+// SetParams implements TunableHandler.
+func (h *TransactionHandler) SetParams(p HandlerParams) error {
+	if p.CPUDuration != nil || p.SQLDuration != nil || p.CGO != nil || p.Encoder != nil {
+		return fmt.Errorf("only powDifficulty is supported by transaction handlers")
+	} else if p.PowDifficulty == nil {
+		return nil
+	} else if err := validatePowDifficulty(*p.PowDifficulty); err != nil {
+		return err
+	}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.PowDifficultiy = *p.PowDifficulty
+	return nil
+}
+
+// maxPowDifficulty is the highest supported proof of work difficulty. Every
+// level multiplies the expected number of hashes by 16, at 6 a proof takes
+// several seconds already, and at 8 it practically never finishes.
+const maxPowDifficulty = 6
+
+func validatePowDifficulty(difficulty int) error {
+	if difficulty < 0 || difficulty > maxPowDifficulty {
+		return fmt.Errorf("powDifficulty must be between 0 and %d: %d", maxPowDifficulty, difficulty)
+	}
+	return nil
+}
+
+var errPoWFailure = errors.New("pow failure")
+
+// doPoW calculates and verifies a proof of work for data. The work is done in
+// a "pow" region of the request's trace task in ctx.
+func doPoW(ctx context.Context, data string, difficulty int) bool {
 	var (
 		wg     sync.WaitGroup
 		result bool
@@ -55,8 +147,10 @@ func doPoW(data string, difficulty int) bool {
 	wg.Add(1)
 	go func() {
 		defer wg.Done()
-		pow, nonce := calculatePoW(difficulty, data)
-		result = verifyPoW(data, pow, difficulty, nonce)
+		trace.WithRegion(ctx, "pow", func() {
+			pow, nonce := calculatePoW(difficulty, data)
+			result = verifyPoW(data, pow, difficulty, nonce)
+		})
 	}()
 	wg.Wait()
 
@@ -87,6 +181,9 @@ func verifyPoW(data, pow string, difficulty, nonce int) bool {
 }
 
 func verifyDifficulty(pow string, difficulty int) bool {
+	if difficulty > len(pow) {
+		return false
+	}
 	for j := 0; j < difficulty; j++ {
 		if pow[j] != '0' {
 			return false
@@ -1,6 +1,7 @@
 package main
 
 import (
+	"context"
 	"testing"
 )
 
@@ -29,6 +30,6 @@ func Test_calculatePoW(t *testing.T) {
 
 func Benchmark_doPoW(b *testing.B) {
 	for i := 0; i < b.N; i++ {
-		doPoW("foo", 3)
+		doPoW(context.Background(), "foo", 3)
 	}
 }
@@ -0,0 +1,420 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"runtime"
+	"runtime/pprof"
+	"text/tabwriter"
+	"time"
+
+	"github.com/google/pprof/profile"
+)
+
+// runVerify implements the verify subcommand. It serves the posts and
+// transaction endpoints of a scenario in-process, puts load on them while
+// capturing a CPU and a wall profile, and checks that the profiles show the
+// CPU and wall time split configured for the endpoints.
+func runVerify(args []string) error {
+	var (
+		fs        = flag.NewFlagSet("verify", flag.ExitOnError)
+		durationF = fs.Duration("duration", 10*time.Second, "Duration of the workload")
+		rpsF      = fs.Float64("rps", 5, "Requests per second across all endpoints")
+		// CPUDuration is the wall time a handler spends burning CPU, so
+		// concurrent requests competing for the CPUs skew the measured split.
+		concurrencyF = fs.Int("concurrency", 1, "Max number of concurrent requests")
+		toleranceF   = fs.Float64("tolerance", 0.1, "Max absolute difference between the expected and measured shares")
+		scenarioF    = fs.String("scenario", "", "Scenario file with the endpoints to verify, defaults to scenarios/default.json")
+		outF         = fs.String("out", "", "Directory to save cpu.pprof and wall.pprof to, defaults to a temp dir")
+	)
+	fs.Parse(args)
+
+	if *concurrencyF < 1 {
+		return fmt.Errorf("-concurrency must be at least 1: %d", *concurrencyF)
+	} else if err := validateLoadRPS(*rpsF); err != nil {
+		return fmt.Errorf("bad -rps: %w", err)
+	}
+	scenario, err := LoadScenario(*scenarioF)
+	if err != nil {
+		return err
+	}
+	v := &profileVerifier{
+		Duration:    *durationF,
+		RPS:         *rpsF,
+		Concurrency: *concurrencyF,
+		Tolerance:   *toleranceF,
+	}
+	fmt.Printf("Running workload for %s\n", *durationF)
+	checks, err := v.Run(context.Background(), scenario)
+	if err != nil {
+		return err
+	}
+
+	dir := *outF
+	if dir == "" {
+		if dir, err = os.MkdirTemp("", "go-prof-app-verify-"); err != nil {
+			return err
+		}
+	} else if err := os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+	for name, p := range map[string]*profile.Profile{"cpu.pprof": v.CPUProfile, "wall.pprof": v.WallProfile} {
+		var buf bytes.Buffer
+		if err := p.Write(&buf); err != nil {
+			return err
+		} else if err := os.WriteFile(filepath.Join(dir, name), buf.Bytes(), 0644); err != nil {
+			return err
+		}
+	}
+	fmt.Printf("Saved profiles to %s\n\n", dir)
+
+	failed := printVerifyChecks(os.Stdout, checks)
+	if failed > 0 {
+		return fmt.Errorf("%d of %d checks failed", failed, len(checks))
+	}
+	return nil
+}
+
+// verifyCheck compares the expected share of some profile samples with the
+// measured one. A check with a Skip reason always passes.
+type verifyCheck struct {
+	Name      string
+	Want      float64
+	Got       float64
+	Tolerance float64
+	Skip      string
+}
+
+func (c verifyCheck) OK() bool {
+	return c.Skip != "" || math.Abs(c.Got-c.Want) <= c.Tolerance
+}
+
+// printVerifyChecks writes a table of checks to w and returns the number of
+// failed checks.
+func printVerifyChecks(w io.Writer, checks []verifyCheck) (failed int) {
+	tw := tabwriter.NewWriter(w, 0, 8, 2, ' ', 0)
+	fmt.Fprintf(tw, "check\twant\tgot\tresult\n")
+	for _, c := range checks {
+		result := "ok"
+		if c.Skip != "" {
+			result = "skipped: " + c.Skip
+		} else if !c.OK() {
+			result = "FAIL"
+			failed++
+		}
+		fmt.Fprintf(tw, "%s\t%.3f\t%.3f\t%s\n", c.Name, c.Want, c.Got, result)
+	}
+	tw.Flush()
+	return failed
+}
+
+const (
+	// wallSampleInterval is the interval at which goroutine profiles are
+	// taken to build the wall profile.
+	wallSampleInterval = 10 * time.Millisecond
+	// minVerifySamples is the number of CPU samples below which the
+	// attribution of calculatePoW isn't checked. The transaction endpoints
+	// only get a few requests in short runs, and their PoW often finishes
+	// within a single sampling period.
+	minVerifySamples = 10
+)
+
+// profileVerifier runs the workload of the verify subcommand.
+type profileVerifier struct {
+	Duration    time.Duration
+	RPS         float64
+	Concurrency int
+	Tolerance   float64
+
+	// Set by Run.
+	CPUProfile  *profile.Profile
+	WallProfile *profile.Profile
+}
+
+// verifyEndpoint is a posts or transaction endpoint under test.
+type verifyEndpoint struct {
+	Path     string
+	Handler  string
+	Params   HandlerParams
+	Requests int
+}
+
+// Run serves the GET posts and transaction endpoints of scenario, loads them
+// and returns the checks comparing the captured profiles with the endpoints'
+// parameters.
+func (v *profileVerifier) Run(ctx context.Context, scenario *Scenario) ([]verifyCheck, error) {
+	db, err := NewMemoryStore("fixed")
+	if err != nil {
+		return nil, err
+	}
+	routes, err := scenario.Routes(db, 4)
+	if err != nil {
+		return nil, err
+	}
+	mux := http.NewServeMux()
+	var endpoints []*verifyEndpoint
+	var mix []loadTarget
+	for _, r := range routes {
+		th, ok := r.Handler.(TunableHandler)
+		if r.Method != "GET" || (r.Name != "posts" && r.Name != "transaction") || !ok {
+			continue
+		}
+		mux.Handle(r.Path, TaskHandler(r.Path, LabelHandler(r.Path, r.Name, r.Handler)))
+		endpoints = append(endpoints, &verifyEndpoint{Path: r.Path, Handler: r.Name, Params: th.Params()})
+		mix = append(mix, loadTarget{Endpoint: r.Path, Weight: 1})
+	}
+	if len(endpoints) == 0 {
+		return nil, fmt.Errorf("scenario has no posts or transaction endpoints")
+	}
+	server := httptest.NewServer(mux)
+	defer server.Close()
+
+	var cpuBuf bytes.Buffer
+	if err := pprof.StartCPUProfile(&cpuBuf); err != nil {
+		return nil, fmt.Errorf("start cpu profile: %w", err)
+	}
+	wallCtx, stopWall := context.WithCancel(ctx)
+	wallCh := make(chan *profile.Profile, 1)
+	wallErrCh := make(chan error, 1)
+	go func() {
+		p, err := sampleWallProfile(wallCtx, wallSampleInterval)
+		wallCh <- p
+		wallErrCh <- err
+	}()
+
+	lg := &loadGenerator{
+		BaseURL:     server.URL,
+		APIKey:      seedAPIKey,
+		Concurrency: v.Concurrency,
+		Client:      &http.Client{Timeout: 30 * time.Second},
+	}
+	report := lg.Run(ctx, loadPhase{RPS: v.RPS, Duration: v.Duration, Mix: mix})
+
+	stopWall()
+	pprof.StopCPUProfile()
+	if v.WallProfile, err = <-wallCh, <-wallErrCh; err != nil {
+		return nil, err
+	}
+	if v.CPUProfile, err = profile.Parse(&cpuBuf); err != nil {
+		return nil, err
+	}
+
+	report.mu.Lock()
+	for _, e := range endpoints {
+		e.Requests = len(report.latencies[e.Path])
+	}
+	report.mu.Unlock()
+	return v.checks(endpoints), nil
+}
+
+// checks returns the checks for the captured profiles.
+//
+// The CPU checks split the samples of goCPUHog, cgoCPUHog and calculatePoW by
+// endpoint label. The expected share of a posts endpoint is its number of
+// requests times its CPUDuration relative to all endpoints using the same
+// hog. The wall check compares the time spent in the hog and in ioWork with
+// the CPUDuration and SQLDuration of each posts endpoint.
+//
+// There is no check of the absolute CPU time of a hog against requests *
+// CPUDuration: a hog overshoots until the scheduler preempts it and competes
+// with the GC for the CPU, so the ratio is biased on machines with few CPUs.
+func (v *profileVerifier) checks(endpoints []*verifyEndpoint) []verifyCheck {
+	cpu := v.CPUProfile
+	var checks []verifyCheck
+
+	hogWant := map[string]float64{}
+	for _, e := range endpoints {
+		if e.Handler == "posts" {
+			hogWant[hogFunc(e)] += float64(e.Requests) * float64(*e.Params.CPUDuration)
+		}
+	}
+	var goWant, allWant = hogWant[funcName(goCPUHog)], hogWant[funcName(goCPUHog)] + hogWant[funcName(cgoCPUHog)]
+	if goWant > 0 && allWant > goWant {
+		checks = append(checks, verifyCheck{
+			Name:      "cpu goCPUHog vs cgoCPUHog",
+			Want:      goWant / allWant,
+			Got:       profileShare(cpu, inFunc(funcName(goCPUHog)), orMatch(inFunc(funcName(goCPUHog)), inFunc(funcName(cgoCPUHog)))),
+			Tolerance: v.Tolerance,
+		})
+	}
+
+	for _, e := range endpoints {
+		switch e.Handler {
+		case "posts":
+			fn := hogFunc(e)
+			onPath := hasLabel("endpoint", e.Path)
+			want := float64(e.Requests) * float64(*e.Params.CPUDuration)
+			checks = append(checks, verifyCheck{
+				Name:      fmt.Sprintf("cpu %s share of %s", fn, e.Path),
+				Want:      want / hogWant[fn],
+				Got:       profileShare(cpu, andMatch(inFunc(fn), onPath), inFunc(fn)),
+				Tolerance: v.Tolerance,
+			})
+			cpuDuration, sqlDuration := float64(*e.Params.CPUDuration), float64(*e.Params.SQLDuration)
+			if cpuDuration+sqlDuration == 0 {
+				continue
+			}
+			// The handler goroutine sleeps while the hog runs, so the hog's
+			// own goroutine is what shows the CPU phase in the wall profile.
+			hog, io := andMatch(onPath, inFunc(fn)), andMatch(onPath, inFunc(funcName((*PostsHandler).ioWork)))
+			checks = append(checks, verifyCheck{
+				Name:      fmt.Sprintf("wall %s vs ioWork of %s", fn, e.Path),
+				Want:      cpuDuration / (cpuDuration + sqlDuration),
+				Got:       profileShare(v.WallProfile, hog, orMatch(hog, io)),
+				Tolerance: v.Tolerance,
+			})
+		case "transaction":
+			pow := inFunc(funcName(calculatePoW))
+			c := verifyCheck{
+				Name:      fmt.Sprintf("cpu calculatePoW attributed to %s", e.Path),
+				Want:      1,
+				Got:       profileShare(cpu, andMatch(pow, hasLabel("endpoint", e.Path)), pow),
+				Tolerance: v.Tolerance,
+			}
+			if samples, _ := profileSum(cpu, pow); samples < minVerifySamples {
+				c.Skip = fmt.Sprintf("%d samples", samples)
+			}
+			checks = append(checks, c)
+		}
+	}
+	return checks
+}
+
+// hogFunc returns the function burning the CPU time of a posts endpoint.
+func hogFunc(e *verifyEndpoint) string {
+	if e.Params.CGO != nil && *e.Params.CGO {
+		return funcName(cgoCPUHog)
+	}
+	return funcName(goCPUHog)
+}
+
+// funcName returns the symbol name of fn as it appears in profiles, e.g.
+// main.goCPUHog, or github.com/felixge/go-prof-app.goCPUHog in tests.
+func funcName(fn interface{}) string {
+	return runtime.FuncForPC(reflect.ValueOf(fn).Pointer()).Name()
+}
+
+// sampleWallProfile takes a goroutine profile every interval until ctx is
+// done, and merges them into a profile of the wall time spent by all
+// goroutines in nanoseconds.
+func sampleWallProfile(ctx context.Context, interval time.Duration) (*profile.Profile, error) {
+	// The profiles are only parsed once ctx is done, to keep the overhead
+	// while sampling low.
+	var bufs []*bytes.Buffer
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		buf := &bytes.Buffer{}
+		if err := pprof.Lookup("goroutine").WriteTo(buf, 0); err != nil {
+			return nil, err
+		}
+		bufs = append(bufs, buf)
+
+		select {
+		case <-ticker.C:
+			continue
+		case <-ctx.Done():
+		}
+		break
+	}
+
+	profiles := make([]*profile.Profile, len(bufs))
+	for i, buf := range bufs {
+		p, err := profile.Parse(buf)
+		if err != nil {
+			return nil, err
+		}
+		profiles[i] = p
+	}
+	wall, err := profile.Merge(profiles)
+	if err != nil {
+		return nil, err
+	}
+	wall.SampleType = []*profile.ValueType{{Type: "wall", Unit: "nanoseconds"}}
+	wall.PeriodType = &profile.ValueType{Type: "wall", Unit: "nanoseconds"}
+	wall.Period = int64(interval)
+	for _, s := range wall.Sample {
+		s.Value[0] *= int64(interval)
+	}
+	return wall, nil
+}
+
+// sampleMatcher selects profile samples.
+type sampleMatcher func(*profile.Sample) bool
+
+// inFunc matches samples with fn anywhere on their stack.
+func inFunc(fn string) sampleMatcher {
+	return func(s *profile.Sample) bool {
+		for _, loc := range s.Location {
+			for _, line := range loc.Line {
+				if line.Function != nil && line.Function.Name == fn {
+					return true
+				}
+			}
+		}
+		return false
+	}
+}
+
+// hasLabel matches samples with the given pprof label.
+func hasLabel(key, value string) sampleMatcher {
+	return func(s *profile.Sample) bool {
+		for _, v := range s.Label[key] {
+			if v == value {
+				return true
+			}
+		}
+		return false
+	}
+}
+
+func andMatch(a, b sampleMatcher) sampleMatcher {
+	return func(s *profile.Sample) bool { return a(s) && b(s) }
+}
+
+func orMatch(a, b sampleMatcher) sampleMatcher {
+	return func(s *profile.Sample) bool { return a(s) || b(s) }
+}
+
+// profileShare returns the share of the last sample value of the samples
+// matched by of that are also matched by part, e.g. the share of the CPU time
+// of a function spent on behalf of an endpoint. It returns 0 if of matches
+// nothing.
+func profileShare(p *profile.Profile, part, of sampleMatcher) float64 {
+	var partSum, ofSum int64
+	for _, s := range p.Sample {
+		if !of(s) {
+			continue
+		}
+		value := s.Value[len(s.Value)-1]
+		ofSum += value
+		if part(s) {
+			partSum += value
+		}
+	}
+	if ofSum == 0 {
+		return 0
+	}
+	return float64(partSum) / float64(ofSum)
+}
+
+// profileSum returns the number of samples matched by match and the sum of
+// their last sample value, e.g. the CPU time in nanoseconds.
+func profileSum(p *profile.Profile, match sampleMatcher) (samples, sum int64) {
+	for _, s := range p.Sample {
+		if match(s) {
+			samples += s.Value[0]
+			sum += s.Value[len(s.Value)-1]
+		}
+	}
+	return samples, sum
+}
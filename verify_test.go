@@ -0,0 +1,74 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"testing"
+	"time"
+
+	"github.com/google/pprof/profile"
+)
+
+func Test_profileVerifier(t *testing.T) {
+	if testing.Short() {
+		t.Skip("runs a workload for several seconds")
+	}
+	// The verifier captures its own CPU profile, which fails if the test
+	// binary is already profiling, e.g. with go test -cpuprofile.
+	if f := flag.Lookup("test.cpuprofile"); f != nil && f.Value.String() != "" {
+		t.Skip("cpu profiling is already in use")
+	}
+	scenario, err := LoadScenario("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	v := &profileVerifier{
+		Duration:    3 * time.Second,
+		RPS:         5,
+		Concurrency: 1,
+		Tolerance:   0.25,
+	}
+	checks, err := v.Run(context.Background(), scenario)
+	if err != nil {
+		t.Fatal(err)
+	} else if len(checks) == 0 {
+		t.Fatalf("got no checks")
+	}
+	for _, c := range checks {
+		if c.Skip != "" {
+			t.Logf("%s: skipped: %s", c.Name, c.Skip)
+		} else if !c.OK() {
+			t.Fatalf("%s: got=%.3f want=%.3f", c.Name, c.Got, c.Want)
+		}
+	}
+	if len(v.CPUProfile.Sample) == 0 || len(v.WallProfile.Sample) == 0 {
+		t.Fatalf("got empty profiles")
+	}
+}
+
+func Test_profileShare(t *testing.T) {
+	fn := func(name string) *profile.Location {
+		return &profile.Location{Line: []profile.Line{{Function: &profile.Function{Name: name}}}}
+	}
+	p := &profile.Profile{Sample: []*profile.Sample{
+		{Location: []*profile.Location{fn("main.goCPUHog")}, Value: []int64{1, 30}, Label: map[string][]string{"endpoint": {"/a"}}},
+		{Location: []*profile.Location{fn("main.goCPUHog")}, Value: []int64{1, 10}, Label: map[string][]string{"endpoint": {"/b"}}},
+		{Location: []*profile.Location{fn("main.calculatePoW")}, Value: []int64{1, 60}, Label: map[string][]string{"endpoint": {"/a"}}},
+	}}
+
+	tests := []struct {
+		Name string
+		Part sampleMatcher
+		Of   sampleMatcher
+		Want float64
+	}{
+		{"goCPUHog of /a", andMatch(inFunc("main.goCPUHog"), hasLabel("endpoint", "/a")), inFunc("main.goCPUHog"), 0.75},
+		{"/a", hasLabel("endpoint", "/a"), orMatch(inFunc("main.goCPUHog"), inFunc("main.calculatePoW")), 0.9},
+		{"no samples", inFunc("main.cgoCPUHog"), inFunc("main.cgoCPUHog"), 0},
+	}
+	for _, test := range tests {
+		if got := profileShare(p, test.Part, test.Of); got != test.Want {
+			t.Fatalf("%s: got=%v want=%v", test.Name, got, test.Want)
+		}
+	}
+}